@@ -1,6 +1,12 @@
 package config
 
-import "github.com/urfave/cli/v3"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
 
 type StringFlagType struct {
 	name     string
@@ -9,6 +15,23 @@ type StringFlagType struct {
 	EnvVar   string
 }
 
+type BoolFlagType struct {
+	name   string
+	usage  string
+	EnvVar string
+}
+
+type IntFlagType struct {
+	name   string
+	usage  string
+	EnvVar string
+}
+
+type SchemaMapping struct {
+	Source string
+	Target string
+}
+
 type DatabaseConfig struct {
 	Driver         string
 	TargetHost     string
@@ -23,28 +46,158 @@ type DatabaseConfig struct {
 	SourceSchema   string
 	SourceUser     string
 	SourcePassword string
+	Schemas        string
+	Format         string
+
+	// skip confirmation prompts
+	Yes bool
+
+	// targets destructive commands refuse to touch without an override
+	Protected       []string
+	IKnowThisIsProd bool
+
+	// filters
+	Include         []string
+	Exclude         []string
+	SkipPrimaryKeys bool
+	SkipForeignKeys bool
+
+	CopySchemaPrivileges bool
+	WithData             bool
+	AllowDestructive     bool
+	Online               bool
+	LockTimeout          string
+	StatementTimeout     string
+	LockRetries          int
+	NoBackup             bool
+	BackupData           bool
+	BackupDir            string
+	PerTable             bool
+	Resume               bool
+	ProgressFile         string
+
+	Hooks string
+
+	// audit log, written to a table on the target unless turned off
+	AuditFile    string
+	NoAuditTable bool
+
+	SubsetTable string
+	SubsetWhere string
+	MaskConfig  string
+	MaskSecret  string
+
+	Checksums         bool
+	ChecksumChunkSize int
+
+	Output string
+
+	// snapshot files (or sql files for the source) used in place of a live database
+	Source string
+	Target string
+
+	MigrationsDir string
 }
 
 var SupportedDatabases []string = []string{"postgres"}
 
+var SupportedDiffFormats []string = []string{"text", "json", "markdown", "md", "html"}
+
 var Flags []StringFlagType = []StringFlagType{
 	{name: "driver", usage: "Database driver type (postgres, mysql, etc)", EnvVar: "DRIVER", required: true},
 
+	// snapshot or sql files in place of a live database
+	{name: "source", usage: "Snapshot file, or directory of .sql files, to read the source schema from instead of connecting to a database", EnvVar: "SOURCE", required: false},
+	{name: "target", usage: "Snapshot file to read the target schema from instead of connecting to a database (diff only)", EnvVar: "TARGET", required: false},
+
 	// target connection
-	{name: "target-host", usage: "Target database host", EnvVar: "TARGET_HOST", required: true},
-	{name: "target-port", usage: "Target database port", EnvVar: "TARGET_PORT", required: true},
-	{name: "target-database", usage: "Target database name", EnvVar: "TARGET_DATABASE", required: true},
+	{name: "target-host", usage: "Target database host", EnvVar: "TARGET_HOST", required: false},
+	{name: "target-port", usage: "Target database port", EnvVar: "TARGET_PORT", required: false},
+	{name: "target-database", usage: "Target database name", EnvVar: "TARGET_DATABASE", required: false},
 	{name: "target-schema", usage: "Target schema within the database", EnvVar: "TARGET_SCHEMA", required: false},
-	{name: "target-user", usage: "Target database user", EnvVar: "TARGET_USER", required: true},
+	{name: "target-user", usage: "Target database user", EnvVar: "TARGET_USER", required: false},
 	{name: "target-password", usage: "Target database password", EnvVar: "TARGET_PASSWORD", required: false},
 
 	// source connection
-	{name: "source-host", usage: "Source database host", EnvVar: "SOURCE_HOST", required: true},
-	{name: "source-port", usage: "Source database port", EnvVar: "SOURCE_PORT", required: true},
-	{name: "source-database", usage: "Source database name", EnvVar: "SOURCE_DATABASE", required: true},
+	{name: "source-host", usage: "Source database host", EnvVar: "SOURCE_HOST", required: false},
+	{name: "source-port", usage: "Source database port", EnvVar: "SOURCE_PORT", required: false},
+	{name: "source-database", usage: "Source database name", EnvVar: "SOURCE_DATABASE", required: false},
 	{name: "source-schema", usage: "Source schema within the database", EnvVar: "SOURCE_SCHEMA", required: false},
-	{name: "source-user", usage: "Source database user", EnvVar: "SOURCE_USER", required: true},
+	{name: "source-user", usage: "Source database user", EnvVar: "SOURCE_USER", required: false},
 	{name: "source-password", usage: "Source database password", EnvVar: "SOURCE_PASSWORD", required: false},
+
+	// multiple schemas
+	{name: "schemas", usage: "Comma separated schemas to work on, optionally mapped as source:target (src_a:tgt_a,src_b:tgt_b)", EnvVar: "SCHEMAS", required: false},
+
+	// data copy
+	{name: "subset", usage: "Root table for copy-data to only copy a referentially consistent subset of the data", EnvVar: "SUBSET", required: false},
+	{name: "subset-where", usage: "WHERE clause selecting the rows of the subset root table", EnvVar: "SUBSET_WHERE", required: false},
+	{name: "mask-config", usage: "JSON file with the masking rules applied to copied rows, keyed by table and column", EnvVar: "MASK_CONFIG", required: false},
+	{name: "mask-secret", usage: "Secret key the masking strategies are derived from", EnvVar: "MASK_SECRET", required: false},
+
+	// protected targets
+	{name: "protected", usage: "Comma separated host or database patterns (globs, or /regex/) destructive commands refuse to run against", EnvVar: "PROTECTED", required: false},
+
+	// timeouts, as go durations (5s, 500ms, 2m)
+	{name: "lock-timeout", usage: "How long replace and sync wait for a lock before giving up, e.g. 5s", EnvVar: "LOCK_TIMEOUT", required: false},
+	{name: "statement-timeout", usage: "How long a single statement of replace and sync may run, e.g. 10m", EnvVar: "STATEMENT_TIMEOUT", required: false},
+
+	// hooks
+	{name: "hooks", usage: "JSON file with sql files and shell commands to run before and after replace and sync", EnvVar: "HOOKS", required: false},
+
+	// audit log
+	{name: "audit-file", usage: "JSONL file every run that writes to the target is appended to, also read by history", EnvVar: "AUDIT_FILE", required: false},
+
+	// backups
+	{name: "backup-dir", usage: "Directory replace writes its backup of the target to (defaults to backups)", EnvVar: "BACKUP_DIR", required: false},
+
+	// per table replace
+	{name: "progress-file", usage: "File a per table replace keeps its progress in, for --resume (defaults to gograte-progress.json)", EnvVar: "PROGRESS_FILE", required: false},
+
+	// migrations
+	{name: "migrations-dir", usage: "Directory with the NNN_name.up.sql/.down.sql migration files (defaults to migrations)", EnvVar: "MIGRATIONS_DIR", required: false},
+
+	// output
+	{name: "format", usage: "Output format for diff (text, json, markdown, html)", EnvVar: "FORMAT", required: false},
+	{name: "output", usage: "File to write the snapshot to (defaults to stdout)", EnvVar: "OUTPUT", required: false},
+
+	// filters
+	{name: "include", usage: "Comma separated table patterns to include (globs, or /regex/)", EnvVar: "INCLUDE", required: false},
+	{name: "exclude", usage: "Comma separated table patterns to exclude (globs, or /regex/)", EnvVar: "EXCLUDE", required: false},
+}
+
+var BoolFlags []BoolFlagType = []BoolFlagType{
+	{name: "yes", usage: "Do not ask for confirmation before replace, sync or copy-data change the target", EnvVar: "YES"},
+	{name: "i-know-this-is-prod", usage: "Allow destructive commands against a protected target, after typing its host name", EnvVar: "I_KNOW_THIS_IS_PROD"},
+
+	{name: "no-audit-table", usage: "Do not record runs in the gograte_audit table on the target", EnvVar: "NO_AUDIT_TABLE"},
+
+	// object kinds
+	{name: "skip-pks", usage: "Do not create primary keys", EnvVar: "SKIP_PKS"},
+	{name: "skip-fks", usage: "Do not create foreign keys", EnvVar: "SKIP_FKS"},
+
+	// replace
+	{name: "copy-schema-privileges", usage: "Give target schemas created by replace the owner and grants of their source schema", EnvVar: "COPY_SCHEMA_PRIVILEGES"},
+	{name: "with-data", usage: "Copy all rows from the source tables into the replaced target tables", EnvVar: "WITH_DATA"},
+	{name: "no-backup", usage: "Do not back up the target tables before replace drops them", EnvVar: "NO_BACKUP"},
+	{name: "backup-data", usage: "Include the rows of the target tables in the backup taken before replace", EnvVar: "BACKUP_DATA"},
+	{name: "per-table", usage: "Have replace commit every table on its own, so a failed run can be continued with --resume", EnvVar: "PER_TABLE"},
+	{name: "resume", usage: "Continue a per table replace that failed, from its progress file", EnvVar: "RESUME"},
+
+	// sync
+	{name: "allow-destructive", usage: "Let sync run changes that lose data, like dropping columns and tables", EnvVar: "ALLOW_DESTRUCTIVE"},
+	{name: "online", usage: "Have sync (and the diff plan) build indexes and validate constraints outside the main transaction", EnvVar: "ONLINE"},
+
+	// verify
+	{name: "checksums", usage: "Have verify compare checksums of the table contents, not only row counts", EnvVar: "CHECKSUMS"},
+}
+
+var IntFlags []IntFlagType = []IntFlagType{
+	// replace
+	{name: "lock-retries", usage: "How often replace and sync retry after running into the lock timeout (defaults to 3)", EnvVar: "LOCK_RETRIES"},
+
+	// verify
+	{name: "checksum-chunk-size", usage: "Have verify checksum every n rows (ordered by primary key) instead of whole tables", EnvVar: "CHECKSUM_CHUNK_SIZE"},
 }
 
 func GetConfig(cmd *cli.Command) DatabaseConfig {
@@ -62,11 +215,154 @@ func GetConfig(cmd *cli.Command) DatabaseConfig {
 		SourcePort:     cmd.String("source-port"),
 		SourceSchema:   cmd.String("source-schema"),
 		SourceDatabase: cmd.String("source-database"),
+		Schemas:        cmd.String("schemas"),
+		Format:         cmd.String("format"),
+
+		Yes: cmd.Bool("yes"),
+
+		Protected:       splitList(cmd.String("protected")),
+		IKnowThisIsProd: cmd.Bool("i-know-this-is-prod"),
+
+		Include:         splitList(cmd.String("include")),
+		Exclude:         splitList(cmd.String("exclude")),
+		SkipPrimaryKeys: cmd.Bool("skip-pks"),
+		SkipForeignKeys: cmd.Bool("skip-fks"),
+
+		CopySchemaPrivileges: cmd.Bool("copy-schema-privileges"),
+		WithData:             cmd.Bool("with-data"),
+		AllowDestructive:     cmd.Bool("allow-destructive"),
+		Online:               cmd.Bool("online"),
+		LockTimeout:          cmd.String("lock-timeout"),
+		StatementTimeout:     cmd.String("statement-timeout"),
+		LockRetries:          int(cmd.Int("lock-retries")),
+		NoBackup:             cmd.Bool("no-backup"),
+		BackupData:           cmd.Bool("backup-data"),
+		BackupDir:            cmd.String("backup-dir"),
+		PerTable:             cmd.Bool("per-table"),
+		Resume:               cmd.Bool("resume"),
+		ProgressFile:         cmd.String("progress-file"),
+
+		Hooks: cmd.String("hooks"),
+
+		AuditFile:    cmd.String("audit-file"),
+		NoAuditTable: cmd.Bool("no-audit-table"),
+
+		SubsetTable: cmd.String("subset"),
+		SubsetWhere: cmd.String("subset-where"),
+		MaskConfig:  cmd.String("mask-config"),
+		MaskSecret:  cmd.String("mask-secret"),
+
+		Checksums:         cmd.Bool("checksums"),
+		ChecksumChunkSize: int(cmd.Int("checksum-chunk-size")),
+
+		Output: cmd.String("output"),
+
+		Source: cmd.String("source"),
+		Target: cmd.String("target"),
+
+		MigrationsDir: cmd.String("migrations-dir"),
+	}
+
+	if !cmd.IsSet("lock-retries") {
+		dbConfig.LockRetries = 3
+	}
+	if dbConfig.BackupDir == "" {
+		dbConfig.BackupDir = "backups"
+	}
+	if dbConfig.ProgressFile == "" {
+		dbConfig.ProgressFile = "gograte-progress.json"
+	}
+	if dbConfig.MigrationsDir == "" {
+		dbConfig.MigrationsDir = "migrations"
 	}
 
 	return dbConfig
 }
 
+// SchemaMappings pairs up the source and target schemas to work on. --schemas
+// takes precedence, otherwise --source-schema and --target-schema can each hold
+// a comma separated list that is paired up by position. nothing given at all
+// means public -> public
+func (c DatabaseConfig) SchemaMappings() ([]SchemaMapping, error) {
+	var mappings []SchemaMapping
+
+	if c.Schemas != "" {
+		for _, entry := range splitList(c.Schemas) {
+			source, target, mapped := strings.Cut(entry, ":")
+			source, target = strings.TrimSpace(source), strings.TrimSpace(target)
+			if !mapped {
+				target = source
+			}
+			if source == "" || target == "" {
+				return nil, fmt.Errorf("'%v' is not a valid schema mapping", entry)
+			}
+			mappings = append(mappings, SchemaMapping{Source: source, Target: target})
+		}
+		if len(mappings) == 0 {
+			return nil, fmt.Errorf("'%v' does not name any schemas", c.Schemas)
+		}
+	} else {
+		sources := splitList(c.SourceSchema)
+		targets := splitList(c.TargetSchema)
+
+		if len(sources) == 0 && len(targets) == 0 {
+			sources = []string{"public"}
+		}
+		if len(targets) == 0 {
+			targets = sources
+		}
+		if len(sources) == 0 {
+			sources = targets
+		}
+		if len(sources) != len(targets) {
+			return nil, fmt.Errorf("got %v source schemas but %v target schemas", len(sources), len(targets))
+		}
+
+		for i := range sources {
+			mappings = append(mappings, SchemaMapping{Source: sources[i], Target: targets[i]})
+		}
+	}
+
+	// mirroring two source schemas into the same target schema would make them
+	// drop each others tables
+	seen := make(map[string]bool)
+	for _, m := range mappings {
+		if seen[m.Target] {
+			return nil, fmt.Errorf("target schema '%v' is mapped more than once", m.Target)
+		}
+		seen[m.Target] = true
+	}
+
+	return mappings, nil
+}
+
+// Timeouts parses --lock-timeout and --statement-timeout, empty means none
+func (c DatabaseConfig) Timeouts() (time.Duration, time.Duration, error) {
+	var durations [2]time.Duration
+	for i, value := range []string{c.LockTimeout, c.StatementTimeout} {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("'%v' is not a valid timeout, use something like 5s or 500ms", value)
+		}
+		durations[i] = d
+	}
+	return durations[0], durations[1], nil
+}
+
+// splitList turns a comma separated flag value into its trimmed, non-empty parts
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func InitiateFlags() []cli.Flag {
 	var data []cli.Flag = []cli.Flag{}
 
@@ -80,5 +376,21 @@ func InitiateFlags() []cli.Flag {
 		})
 	}
 
+	for _, flagName := range BoolFlags {
+		data = append(data, &cli.BoolFlag{
+			Name:    flagName.name,
+			Usage:   flagName.usage,
+			Sources: cli.EnvVars(flagName.EnvVar),
+		})
+	}
+
+	for _, flagName := range IntFlags {
+		data = append(data, &cli.IntFlag{
+			Name:    flagName.name,
+			Usage:   flagName.usage,
+			Sources: cli.EnvVars(flagName.EnvVar),
+		})
+	}
+
 	return data
 }
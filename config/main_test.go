@@ -0,0 +1,79 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSchemaMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DatabaseConfig
+		want    []SchemaMapping
+		wantErr string
+	}{
+		{
+			name: "nothing given",
+			want: []SchemaMapping{{Source: "public", Target: "public"}},
+		},
+		{
+			name:   "source schema only",
+			config: DatabaseConfig{SourceSchema: "app"},
+			want:   []SchemaMapping{{Source: "app", Target: "app"}},
+		},
+		{
+			name:   "target schema only",
+			config: DatabaseConfig{TargetSchema: "app"},
+			want:   []SchemaMapping{{Source: "app", Target: "app"}},
+		},
+		{
+			name:   "lists paired by position",
+			config: DatabaseConfig{SourceSchema: "app, billing", TargetSchema: "app_copy,billing_copy"},
+			want:   []SchemaMapping{{Source: "app", Target: "app_copy"}, {Source: "billing", Target: "billing_copy"}},
+		},
+		{
+			name:    "lists of different length",
+			config:  DatabaseConfig{SourceSchema: "app,billing", TargetSchema: "app"},
+			wantErr: "got 2 source schemas but 1 target schemas",
+		},
+		{
+			name:   "schemas with and without a mapping",
+			config: DatabaseConfig{Schemas: "app:app_copy, billing,", SourceSchema: "ignored"},
+			want:   []SchemaMapping{{Source: "app", Target: "app_copy"}, {Source: "billing", Target: "billing"}},
+		},
+		{
+			name:    "half a mapping",
+			config:  DatabaseConfig{Schemas: "app:"},
+			wantErr: "'app:' is not a valid schema mapping",
+		},
+		{
+			name:    "schemas without any names",
+			config:  DatabaseConfig{Schemas: " , "},
+			wantErr: "' , ' does not name any schemas",
+		},
+		{
+			name:    "two sources into one target",
+			config:  DatabaseConfig{Schemas: "app:shared,billing:shared"},
+			wantErr: "target schema 'shared' is mapped more than once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.SchemaMappings()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SchemaMappings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
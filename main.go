@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
 	"github.com/joho/godotenv"
 	"github.com/urfave/cli/v3"
 )
@@ -23,7 +24,7 @@ func main() {
 
 	cmd := &cli.Command{
 		Flags: config.InitiateFlags(),
-		Action: func(ctx context.Context, cmd *cli.Command) error {
+		Action: func(ctx context.Context, cmd *cli.Command) (err error) {
 			method := strings.ToLower(cmd.Args().Get(0))
 			if method == "" {
 				return fmt.Errorf("no command provided")
@@ -36,6 +37,12 @@ func main() {
 				for _, v := range config.Flags {
 					data.WriteString(fmt.Sprintf("%s=\n", v.EnvVar))
 				}
+				for _, v := range config.BoolFlags {
+					data.WriteString(fmt.Sprintf("%s=\n", v.EnvVar))
+				}
+				for _, v := range config.IntFlags {
+					data.WriteString(fmt.Sprintf("%s=\n", v.EnvVar))
+				}
 
 				os.WriteFile(".env", []byte(data.String()), 0644)
 				fmt.Println(".env file created in project root")
@@ -51,30 +58,277 @@ func main() {
 				return fmt.Errorf("'%v' is not a supported database driver", dbConfig.Driver)
 			}
 
-			// connect to both the target and source databases
-			sourceDbConn, err := postgres.ConnectToPostgres(dbConfig.SourceHost, dbConfig.SourceDatabase, dbConfig.SourceUser, dbConfig.SourcePassword, dbConfig.SourcePort, dbConfig.SourceSchema)
+			if dbConfig.Format != "" && !slices.Contains(config.SupportedDiffFormats, strings.ToLower(dbConfig.Format)) {
+				return fmt.Errorf("'%v' is not a supported diff format", dbConfig.Format)
+			}
+
+			if dbConfig.SubsetWhere != "" && dbConfig.SubsetTable == "" {
+				return fmt.Errorf("--subset-where needs a --subset table")
+			}
+
+			filter, err := postgres.NewFilter(dbConfig.Include, dbConfig.Exclude, dbConfig.SkipPrimaryKeys, dbConfig.SkipForeignKeys)
+			if err != nil {
+				return err
+			}
+
+			masker, err := postgres.LoadMaskConfig(dbConfig.MaskConfig, dbConfig.MaskSecret)
 			if err != nil {
 				return err
 			}
-			defer sourceDbConn.Close(ctx)
 
-			targetDbConn, err := postgres.ConnectToPostgres(dbConfig.TargetHost, dbConfig.TargetDatabase, dbConfig.TargetUser, dbConfig.TargetPassword, dbConfig.TargetPort, dbConfig.TargetSchema)
+			hooks, err := postgres.LoadHooks(dbConfig.Hooks)
 			if err != nil {
 				return err
 			}
-			defer targetDbConn.Close(ctx)
+
+			lockTimeout, statementTimeout, err := dbConfig.Timeouts()
+			if err != nil {
+				return err
+			}
+			if dbConfig.LockRetries < 0 {
+				return fmt.Errorf("--lock-retries can not be negative")
+			}
+
+			schemaMappings, err := dbConfig.SchemaMappings()
+			if err != nil {
+				return err
+			}
+
+			var schemas []postgres.SchemaMapping
+			for _, m := range schemaMappings {
+				schemas = append(schemas, postgres.SchemaMapping{Source: m.Source, Target: m.Target})
+			}
+
+			// a side that points at a snapshot file is read from disk, otherwise
+			// connect to the database. a source of sql files is loaded into a
+			// throwaway schema on the target further down
+			var sourceDbConn, targetDbConn *pgx.Conn
+			var source, target postgres.SchemaSource
+
+			// migrate, history and rollback only work on the target
+			needsSource := method != "migrate" && method != "history" && method != "rollback"
+
+			// every run that writes to the target is recorded in the audit log,
+			// which traces the statements sent over the target connection
+			migrateAction := strings.ToLower(cmd.Args().Get(1))
+			writes := method == "replace" || method == "sync" || method == "copy-data" || method == "rollback" || (method == "migrate" && migrateAction != "status")
+
+			var audit *postgres.Audit
+			var tracer pgx.QueryTracer
+			if writes && dbConfig.Driver == "postgres" && (!dbConfig.NoAuditTable || dbConfig.AuditFile != "") {
+				command := method
+				if method == "migrate" || method == "rollback" {
+					command += " " + cmd.Args().Get(1)
+				}
+				audit = postgres.NewAudit(command)
+				tracer = audit
+			}
+
+			isDDLSource := postgres.IsDDLSource(dbConfig.Source)
+			// sql files describe a single schema, every schema would be compared
+			// against the same tables
+			if needsSource && isDDLSource && len(schemas) > 1 {
+				return fmt.Errorf("a source of sql files describes a single schema, it can not be used with %v schemas", len(schemas))
+			}
+			if needsSource && dbConfig.Source == "" {
+				sourceDbConn, err = postgres.ConnectToPostgres(dbConfig.SourceHost, dbConfig.SourceDatabase, dbConfig.SourceUser, dbConfig.SourcePassword, dbConfig.SourcePort, dbConfig.SourceSchema, nil)
+				if err != nil {
+					return err
+				}
+				defer sourceDbConn.Close(ctx)
+				source = postgres.LiveSchema(sourceDbConn)
+			} else if needsSource && !isDDLSource {
+				source, err = postgres.LoadSnapshot(dbConfig.Source)
+				if err != nil {
+					return err
+				}
+			}
+
+			// snapshot only reads the source, it needs no target unless the sql
+			// files have to be loaded somewhere. history can read the audit file
+			needsTarget := (method != "snapshot" && !(method == "history" && dbConfig.NoAuditTable)) || isDDLSource
+
+			if needsTarget && dbConfig.Target != "" {
+				target, err = postgres.LoadSnapshot(dbConfig.Target)
+				if err != nil {
+					return err
+				}
+			} else if needsTarget {
+				targetDbConn, err = postgres.ConnectToPostgres(dbConfig.TargetHost, dbConfig.TargetDatabase, dbConfig.TargetUser, dbConfig.TargetPassword, dbConfig.TargetPort, dbConfig.TargetSchema, tracer)
+				if err != nil {
+					return err
+				}
+				defer targetDbConn.Close(ctx)
+				target = postgres.LiveSchema(targetDbConn)
+			}
+
+			if needsSource && isDDLSource {
+				if targetDbConn == nil {
+					return fmt.Errorf("a source of sql files needs a live target database to load them into")
+				}
+				source, err = postgres.LoadDDL(dbConfig.Source, targetDbConn)
+				if err != nil {
+					return err
+				}
+			}
+
+			if method == "snapshot" {
+				if dbConfig.Driver == "postgres" {
+					return postgres.SnapshotMethod(source, ctx, s, schemas, filter, dbConfig.Output)
+				}
+			}
+
+			// history and rollback read the audit table, or the file when the
+			// table is turned off
+			auditFile := ""
+			if dbConfig.NoAuditTable {
+				auditFile = dbConfig.AuditFile
+			}
+			if (method == "history" || method == "rollback") && dbConfig.NoAuditTable && dbConfig.AuditFile == "" {
+				return fmt.Errorf("%v needs the audit table or an --audit-file to read from", method)
+			}
+
+			if method == "history" {
+				if dbConfig.Driver == "postgres" {
+					return postgres.HistoryMethod(targetDbConn, ctx, cmd.Args().Get(1), schemas[0].Target, auditFile)
+				}
+			}
+
+			// only diff works entirely offline, the rest writes to the target or
+			// reads rows from the source
+			if method != "diff" && targetDbConn == nil {
+				return fmt.Errorf("%v needs a live target database, not a file", method)
+			}
+			if (method == "copy-data" || method == "verify") && sourceDbConn == nil {
+				return fmt.Errorf("%v needs a live source database, not a file", method)
+			}
+
+			// registered before the lock and the guard so a run they stop is
+			// recorded too, err is whatever the run ends up returning
+			if audit != nil {
+				defer func() {
+					if auditErr := audit.Finish(targetDbConn, ctx, err, schemas[0].Target, !dbConfig.NoAuditTable, dbConfig.AuditFile); auditErr != nil {
+						fmt.Println("error while writing audit log: " + auditErr.Error())
+						if err == nil {
+							err = auditErr
+						}
+					}
+				}()
+
+				if source != nil {
+					if err := audit.SetSource(source, ctx, s, schemas, filter); err != nil {
+						fmt.Println("error while fingerprinting source schema")
+						return err
+					}
+				}
+			}
+
+			// only one gograte run at a time may write to a target
+			if writes && dbConfig.Driver == "postgres" {
+				unlock, err := postgres.LockTarget(targetDbConn, ctx)
+				if err != nil {
+					return err
+				}
+				defer unlock()
+			}
+
+			// a protected target has to be asked for explicitly
+			destructive := method == "replace" || method == "copy-data" || method == "rollback" || (method == "migrate" && migrateAction == "down") || (method == "sync" && dbConfig.AllowDestructive)
+			if destructive && dbConfig.Driver == "postgres" {
+				if err := postgres.GuardProtectedTarget(targetDbConn, ctx, method, dbConfig.Protected, dbConfig.IKnowThisIsProd); err != nil {
+					return err
+				}
+			}
+
+			// the way back is worked out before anything changes, and kept with
+			// the run in the audit log
+			// a resumed replace has already changed the target, the script
+			// is with the run that started it
+			if audit != nil && (method == "sync" || (method == "replace" && !dbConfig.Resume)) {
+				if err := audit.PrepareRollback(targetDbConn, source, ctx, s, schemas, filter, method); err != nil {
+					return err
+				}
+			}
 
 			switch method {
 			case "replace":
 				if dbConfig.Driver == "postgres" {
-					if err := postgres.ReplaceMethod(targetDbConn, sourceDbConn, ctx, s, dbConfig.SourceSchema, dbConfig.TargetSchema); err != nil {
+					if err := postgres.ReplaceMethod(targetDbConn, source, ctx, s, schemas, filter, postgres.ReplaceOptions{
+						CopySchemaPrivileges: dbConfig.CopySchemaPrivileges,
+						WithData:             dbConfig.WithData,
+						Masker:               masker,
+						Backup:               !dbConfig.NoBackup,
+						BackupData:           dbConfig.BackupData,
+						BackupDir:            dbConfig.BackupDir,
+						Yes:                  dbConfig.Yes,
+						Hooks:                hooks,
+						PerTable:             dbConfig.PerTable,
+						Resume:               dbConfig.Resume,
+						ProgressFile:         dbConfig.ProgressFile,
+						Timeouts: postgres.Timeouts{
+							Lock:        lockTimeout,
+							Statement:   statementTimeout,
+							LockRetries: dbConfig.LockRetries,
+						},
+					}); err != nil {
+						return err
+					}
+				}
+
+			case "copy-data":
+				if dbConfig.Driver == "postgres" {
+					if err := postgres.CopyDataMethod(targetDbConn, sourceDbConn, ctx, s, schemas, filter, postgres.Subset{
+						Table: dbConfig.SubsetTable,
+						Where: dbConfig.SubsetWhere,
+					}, masker, dbConfig.Yes); err != nil {
+						return err
+					}
+				}
+
+			case "verify":
+				if dbConfig.Driver == "postgres" {
+					if err := postgres.VerifyMethod(targetDbConn, sourceDbConn, ctx, s, schemas, filter, postgres.VerifyOptions{
+						Checksums: dbConfig.Checksums || dbConfig.ChecksumChunkSize > 0,
+						ChunkSize: dbConfig.ChecksumChunkSize,
+					}); err != nil {
+						return err
+					}
+				}
+
+			case "migrate":
+				if dbConfig.Driver == "postgres" {
+					if err := postgres.MigrateMethod(targetDbConn, ctx, s, migrateAction, dbConfig.MigrationsDir, schemas, filter); err != nil {
+						return err
+					}
+				}
+
+			case "sync":
+				if dbConfig.Driver == "postgres" {
+					if err := postgres.SyncMethod(targetDbConn, source, ctx, s, schemas, filter, postgres.SyncOptions{
+						AllowDestructive: dbConfig.AllowDestructive,
+						Online:           dbConfig.Online,
+						Hooks:            hooks,
+						Yes:              dbConfig.Yes,
+						Timeouts: postgres.Timeouts{
+							Lock:        lockTimeout,
+							Statement:   statementTimeout,
+							LockRetries: dbConfig.LockRetries,
+						},
+					}); err != nil {
+						return err
+					}
+				}
+
+			case "rollback":
+				if dbConfig.Driver == "postgres" {
+					if err := postgres.RollbackMethod(targetDbConn, ctx, s, cmd.Args().Get(1), schemas[0].Target, auditFile, dbConfig.Yes); err != nil {
 						return err
 					}
 				}
 
 			case "diff":
 				if dbConfig.Driver == "postgres" {
-					if err := postgres.DiffMethod(targetDbConn, sourceDbConn, ctx, s, dbConfig.TargetSchema, dbConfig.SourceSchema); err != nil {
+					if err := postgres.DiffMethod(target, source, ctx, s, schemas, dbConfig.Format, filter, dbConfig.Online); err != nil {
 						return err
 					}
 				}
@@ -0,0 +1,346 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+const auditTable = "gograte_audit"
+
+// AuditRun is the record of a single gograte run against a target
+type AuditRun struct {
+	ID                string           `json:"id" db:"id"`
+	StartedAt         time.Time        `json:"started_at" db:"started_at"`
+	FinishedAt        time.Time        `json:"finished_at" db:"finished_at"`
+	OSUser            string           `json:"os_user" db:"os_user"`
+	DatabaseUser      string           `json:"database_user" db:"database_user"`
+	Target            string           `json:"target" db:"target"`
+	Command           string           `json:"command" db:"command"`
+	Source            string           `json:"source,omitempty" db:"source"`
+	SourceFingerprint string           `json:"source_fingerprint,omitempty" db:"source_fingerprint"`
+	Status            string           `json:"status" db:"status"` // success, failed or aborted
+	Error             string           `json:"error,omitempty" db:"error"`
+	Statements        []AuditStatement `json:"statements" db:"statements"`
+
+	// sql that puts the target back the way it was before the run, for
+	// replace and sync
+	Rollback string `json:"rollback,omitempty" db:"rollback"`
+}
+
+type AuditStatement struct {
+	SQL   string `json:"sql"`
+	Error string `json:"error,omitempty"`
+}
+
+// Audit records every statement gograte sends to the target. it is a pgx
+// tracer, so it has to be handed to the connection when it is made. reads
+// are left out, they change nothing
+type Audit struct {
+	run     AuditRun
+	pending string
+	stopped bool
+}
+
+// NewAudit starts the record of a run of the given command
+func NewAudit(command string) *Audit {
+	id := make([]byte, 3)
+	rand.Read(id)
+
+	osUser := "unknown"
+	if u, err := user.Current(); err == nil {
+		osUser = u.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		osUser += "@" + hostname
+	}
+
+	return &Audit{run: AuditRun{
+		ID:         time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(id),
+		StartedAt:  time.Now(),
+		OSUser:     osUser,
+		Command:    command,
+		Statements: []AuditStatement{},
+	}}
+}
+
+// ID is the id the run is recorded under
+func (a *Audit) ID() string {
+	return a.run.ID
+}
+
+func (a *Audit) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	a.pending = ""
+	if !a.stopped && !isReadStatement(data.SQL) {
+		a.pending = strings.TrimSpace(data.SQL)
+	}
+	return ctx
+}
+
+func (a *Audit) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if a.pending == "" {
+		return
+	}
+	statement := AuditStatement{SQL: a.pending}
+	if data.Err != nil {
+		statement.Error = data.Err.Error()
+	}
+	a.run.Statements = append(a.run.Statements, statement)
+	a.pending = ""
+}
+
+func (a *Audit) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	a.pending = ""
+	if !a.stopped {
+		quoted := make([]string, 0, len(data.ColumnNames))
+		for _, name := range data.ColumnNames {
+			quoted = append(quoted, quoteIdentifier(name))
+		}
+		a.pending = fmt.Sprintf("COPY %s (%s) FROM STDIN;", data.TableName.Sanitize(), strings.Join(quoted, ", "))
+	}
+	return ctx
+}
+
+func (a *Audit) TraceCopyFromEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	a.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{CommandTag: data.CommandTag, Err: data.Err})
+}
+
+func isReadStatement(sql string) bool {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return true
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH", "SHOW", "EXPLAIN":
+		return true
+	}
+	return false
+}
+
+// SetSource notes where the run got its schema from, with a fingerprint of
+// that schema so runs from the same source state can be matched up
+func (a *Audit) SetSource(source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter) error {
+	spinner.Start()
+	snapshot, _, err := buildSnapshot(source, ctx, spinner, schemas, filter)
+	spinner.Stop()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+
+	a.run.Source = source.describe()
+	a.run.SourceFingerprint = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// Finish records how the run ended, in the audit table of historySchema
+// and/or appended to file
+func (a *Audit) Finish(targetDbConn *pgx.Conn, ctx context.Context, runErr error, historySchema string, toTable bool, file string) error {
+	a.stopped = true
+
+	a.run.FinishedAt = time.Now()
+	a.run.DatabaseUser = targetDbConn.Config().User
+	a.run.Target = describeTarget(targetDbConn)
+	a.run.Status = "success"
+	if errors.Is(runErr, ErrAborted) {
+		a.run.Status = "aborted"
+	} else if runErr != nil {
+		a.run.Status = "failed"
+		a.run.Error = runErr.Error()
+	}
+
+	if toTable {
+		if err := ensureAuditTable(targetDbConn, ctx, historySchema); err != nil {
+			return err
+		}
+
+		statements, err := json.Marshal(a.run.Statements)
+		if err != nil {
+			return err
+		}
+		_, err = targetDbConn.Exec(ctx, fmt.Sprintf(`
+			INSERT INTO %s (id, started_at, finished_at, os_user, database_user, target, command, source, source_fingerprint, status, error, statements, rollback)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);
+		`, quoteIdentifier(historySchema, auditTable)),
+			a.run.ID, a.run.StartedAt, a.run.FinishedAt, a.run.OSUser, a.run.DatabaseUser, a.run.Target, a.run.Command,
+			a.run.Source, a.run.SourceFingerprint, a.run.Status, a.run.Error, string(statements), a.run.Rollback)
+		if err != nil {
+			fmt.Println("error while writing audit record")
+			return err
+		}
+	}
+
+	if file != "" {
+		line, err := json.Marshal(a.run)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Println("error while opening audit file")
+			return err
+		}
+		defer f.Close()
+
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			fmt.Println("error while writing audit file")
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ensureAuditTable(targetDbConn *pgx.Conn, ctx context.Context, historySchema string) error {
+	_, err := targetDbConn.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id text PRIMARY KEY,
+			started_at timestamptz NOT NULL,
+			finished_at timestamptz NOT NULL,
+			os_user text NOT NULL,
+			database_user text NOT NULL,
+			target text NOT NULL,
+			command text NOT NULL,
+			source text NOT NULL,
+			source_fingerprint text NOT NULL,
+			status text NOT NULL,
+			error text NOT NULL,
+			statements jsonb NOT NULL,
+			rollback text NOT NULL DEFAULT ''
+		);
+	`, quoteIdentifier(historySchema, auditTable)))
+	if err != nil {
+		fmt.Println("error while creating audit table")
+		return err
+	}
+	return nil
+}
+
+// loadAuditRuns reads past runs, newest first, from the audit table or, when
+// file is set, from the audit file
+func loadAuditRuns(targetDbConn *pgx.Conn, ctx context.Context, historySchema, file string) ([]AuditRun, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading audit file: %w", err)
+		}
+
+		var runs []AuditRun
+		for i, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var run AuditRun
+			if err := json.Unmarshal([]byte(line), &run); err != nil {
+				return nil, fmt.Errorf("error while parsing line %v of %v: %w", i+1, file, err)
+			}
+			runs = append([]AuditRun{run}, runs...)
+		}
+		return runs, nil
+	}
+
+	// reading the history changes nothing, not even creating the table
+	table := quoteIdentifier(historySchema, auditTable)
+	var exists bool
+	if err := targetDbConn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL;", table).Scan(&exists); err != nil {
+		fmt.Println("error while looking up audit table")
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := targetDbConn.Query(ctx, fmt.Sprintf(`
+		SELECT id, started_at, finished_at, os_user, database_user, target, command, source, source_fingerprint, status, error, statements, rollback
+		FROM %s
+		ORDER BY started_at DESC;
+	`, table))
+	if err != nil {
+		fmt.Println("error while querying audit table")
+		return nil, err
+	}
+
+	runs, err := pgx.CollectRows(rows, pgx.RowToStructByName[AuditRun])
+	if err != nil {
+		fmt.Println("error while collecting audit rows")
+		return nil, err
+	}
+	return runs, nil
+}
+
+func HistoryMethod(targetDbConn *pgx.Conn, ctx context.Context, runID, historySchema, file string) error {
+	// lists the runs recorded in the audit log, or everything about one of them
+
+	runs, err := loadAuditRuns(targetDbConn, ctx, historySchema, file)
+	if err != nil {
+		return err
+	}
+
+	if runID == "" {
+		if len(runs) == 0 {
+			fmt.Println("no runs recorded yet")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTARTED\tUSER\tCOMMAND\tSTATUS\tSTATEMENTS")
+		for _, run := range runs {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", run.ID, run.StartedAt.Local().Format(time.DateTime), run.OSUser, run.Command, run.Status, len(run.Statements))
+		}
+		return w.Flush()
+	}
+
+	for _, run := range runs {
+		if run.ID != runID {
+			continue
+		}
+
+		fmt.Printf("id:          %v\n", run.ID)
+		fmt.Printf("command:     %v\n", run.Command)
+		fmt.Printf("status:      %v\n", run.Status)
+		if run.Error != "" {
+			fmt.Printf("error:       %v\n", run.Error)
+		}
+		fmt.Printf("started:     %v\n", run.StartedAt.Local().Format(time.DateTime))
+		fmt.Printf("finished:    %v\n", run.FinishedAt.Local().Format(time.DateTime))
+		fmt.Printf("run by:      %v (database user %v)\n", run.OSUser, run.DatabaseUser)
+		fmt.Printf("target:      %v\n", run.Target)
+		if run.Source != "" {
+			fmt.Printf("source:      %v\n", run.Source)
+			fmt.Printf("fingerprint: %v\n", run.SourceFingerprint)
+		}
+
+		if run.Rollback != "" {
+			fmt.Printf("rollback:    gograte rollback %v\n", run.ID)
+		}
+
+		fmt.Printf("\n%v statements:\n", len(run.Statements))
+		for _, statement := range run.Statements {
+			fmt.Println(statement.SQL)
+			if statement.Error != "" {
+				fmt.Println("-- failed: " + statement.Error)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no run with id '%v' in the audit log", runID)
+}
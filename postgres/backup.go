@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+// backupTarget writes the tables replace is about to drop to a sql file that
+// psql can run to put them back. it knows what gograte knows: tables, columns
+// with their defaults, primary and foreign keys, and with data the rows as
+// COPY blocks
+func backupTarget(targetDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter, dir string, withData bool) (string, error) {
+	spinner.Suffix = " backing up target"
+
+	// keys are part of what gets dropped, even when replace wont recreate them
+	filter.SkipPrimaryKeys = false
+	filter.SkipForeignKeys = false
+
+	// one snapshot so the rows of all tables belong together
+	tx, err := targetDbConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		fmt.Println("error while beginning backup transaction")
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	structures := make(map[string]map[string]Table)
+	for _, schema := range schemas {
+		tables, err := getSchemaDetails(tx, ctx, spinner, schema.Target, true, filter)
+		if err != nil {
+			fmt.Println("error while getting target table schema")
+			return "", err
+		}
+		structures[schema.Target] = tables
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Println("error while creating backup directory")
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("gograte-backup-%v-%v.sql", targetDbConn.Config().Database, time.Now().Format("20060102-150405")))
+	// with --backup-data this is a copy of the rows, only the owner may read it
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Println("error while creating backup file")
+		return "", err
+	}
+	defer file.Close()
+
+	// half a backup looks like a whole one, so it is removed when anything fails
+	complete := false
+	defer func() {
+		if !complete {
+			file.Close()
+			os.Remove(path)
+		}
+	}()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "-- backup of %v/%v taken by gograte at %v\n", targetDbConn.Config().Host, targetDbConn.Config().Database, time.Now().Format(time.RFC3339))
+	fmt.Fprintln(w, "BEGIN;")
+
+	// whatever replace created in the meantime makes way for the old tables
+	for _, schema := range schemas {
+		for _, table := range sortedTableNames(structures[schema.Target]) {
+			fmt.Fprintln(w, generateDropTableQuery(schema.Target, table))
+		}
+	}
+
+	for _, schema := range schemas {
+		for _, table := range sortedTableNames(structures[schema.Target]) {
+			fmt.Fprintln(w, generateCreateTableWithDefaultsQuery(schema.Target, table, structures[schema.Target][table].Columns))
+		}
+	}
+
+	// rows go in before the keys, like replace --with-data does
+	if withData {
+		for _, schema := range schemas {
+			for _, table := range sortedTableNames(structures[schema.Target]) {
+				spinner.Suffix = fmt.Sprintf(" backing up rows of %v.%v", schema.Target, table)
+
+				if err := backupTableData(tx, ctx, w, schema.Target, table, structures[schema.Target][table].Columns); err != nil {
+					fmt.Printf("error while backing up rows of table %v.%v\n", schema.Target, table)
+					return "", err
+				}
+
+				// the serial columns got new sequences, they go on after the restored rows
+				for _, col := range structures[schema.Target][table].Columns {
+					if _, ok := serialType(col); ok {
+						fmt.Fprintln(w, generateRestartSerialQuery(schema.Target, table, col.ColumnName))
+					}
+				}
+			}
+		}
+	}
+
+	for _, schema := range schemas {
+		for _, table := range sortedTableNames(structures[schema.Target]) {
+			if pk := structures[schema.Target][table].PrimaryKey; pk != "" {
+				fmt.Fprintln(w, generatePrimaryKeyQuery(schema.Target, table, pk))
+			}
+		}
+	}
+	for _, schema := range schemas {
+		for _, table := range sortedTableNames(structures[schema.Target]) {
+			for _, fk := range structures[schema.Target][table].ForeignKeys {
+				fmt.Fprintln(w, generateForeignKeyQuery(schema.Target, table, fk.ForeignSchemaName, fk))
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "COMMIT;")
+
+	if err := w.Flush(); err != nil {
+		fmt.Println("error while writing backup file")
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		fmt.Println("error while writing backup file")
+		return "", err
+	}
+
+	complete = true
+	return path, nil
+}
+
+func generateRestartSerialQuery(schema, table, column string) string {
+	return fmt.Sprintf(
+		"SELECT setval(pg_get_serial_sequence(%s, %s), COALESCE(max(%s), 0) + 1, false) FROM %s;",
+		quoteLiteral(quoteIdentifier(schema, table)), quoteLiteral(column), quoteIdentifier(column), quoteIdentifier(schema, table),
+	)
+}
+
+// backupTableData writes the rows of a table as a COPY ... FROM stdin block,
+// the same way pg_dump does
+func backupTableData(tx pgx.Tx, ctx context.Context, w *bufio.Writer, schema, table string, columns []Column) error {
+	if len(columns) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, 0, len(columns))
+	for _, name := range columnNames(columns) {
+		quoted = append(quoted, quoteIdentifier(name))
+	}
+	columnList := strings.Join(quoted, ", ")
+
+	fmt.Fprintf(w, "COPY %s (%s) FROM stdin;\n", quoteIdentifier(schema, table), columnList)
+	if _, err := tx.Conn().PgConn().CopyTo(ctx, w, fmt.Sprintf("COPY %s (%s) TO STDOUT;", quoteIdentifier(schema, table), columnList)); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "\\.")
+
+	return nil
+}
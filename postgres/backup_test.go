@@ -0,0 +1,27 @@
+package postgres
+
+import "testing"
+
+func TestGenerateRestartSerialQuery(t *testing.T) {
+	tests := []struct {
+		schema, table, column string
+		want                  string
+	}{
+		{
+			schema: "public", table: "users", column: "id",
+			want: `SELECT setval(pg_get_serial_sequence('"public"."users"', 'id'), COALESCE(max("id"), 0) + 1, false) FROM "public"."users";`,
+		},
+		{
+			schema: "app", table: "it's", column: "Order ID",
+			want: `SELECT setval(pg_get_serial_sequence('"app"."it''s"', 'Order ID'), COALESCE(max("Order ID"), 0) + 1, false) FROM "app"."it's";`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			if got := generateRestartSerialQuery(tt.schema, tt.table, tt.column); got != tt.want {
+				t.Errorf("generateRestartSerialQuery() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
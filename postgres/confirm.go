@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrAborted is returned when the user says no to a prompt, so deferred
+// cleanup like rolling back still runs
+var ErrAborted = errors.New("aborted")
+
+// answers are read a whole line at a time, so a database name with spaces in
+// it is read in one piece and nothing is left over for the next question
+var stdin = bufio.NewReader(os.Stdin)
+
+func readAnswer() (string, bool) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// askYesNo keeps asking until it gets a y or n back. no input at all, like
+// when running without a terminal, counts as a no
+func askYesNo(question string) bool {
+	for {
+		fmt.Print(question + " (y/n): ")
+		yesno, ok := readAnswer()
+		if !ok {
+			return false
+		}
+
+		switch strings.TrimSpace(strings.ToLower(yesno)) {
+		case "y":
+			return true
+		case "n":
+			return false
+		}
+	}
+}
+
+// askToType only passes when the user types exactly the expected value, a
+// y is too easy to give without reading
+func askToType(question, expected string) bool {
+	fmt.Printf("%v (%v): ", question, expected)
+	answer, ok := readAnswer()
+	return ok && answer == expected
+}
+
+// confirmReplace shows what replace is about to destroy and makes the user
+// type the target database name, unless yes is set
+func confirmReplace(targetDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, source SchemaSource, schemas []SchemaMapping, filter Filter, yes bool) error {
+	fmt.Println("source: " + source.describe())
+	fmt.Println("target: " + targetDbConn.Config().Host)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	numOfTables := 0
+	var numOfRows int64
+	for _, schema := range schemas {
+		tables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, false, filter)
+		if err != nil {
+			fmt.Println("error while getting target table schema")
+			return err
+		}
+
+		for _, table := range sortedTableNames(tables) {
+			// the planners estimate, counting every row of a big table would
+			// take longer than the replace itself
+			var rows int64
+			if err := targetDbConn.QueryRow(ctx, "SELECT reltuples::bigint FROM pg_catalog.pg_class WHERE oid = $1::regclass;", quoteIdentifier(schema.Target, table)).Scan(&rows); err != nil {
+				fmt.Println("error while estimating rows of table " + table)
+				return err
+			}
+
+			if numOfTables == 0 {
+				fmt.Println("\nthese target tables will be dropped:")
+				fmt.Fprintln(w, "TABLE\tROWS (ESTIMATE)")
+			}
+			// never analyzed tables have no estimate yet
+			if rows < 0 {
+				fmt.Fprintf(w, "%v.%v\tunknown\n", schema.Target, table)
+			} else {
+				fmt.Fprintf(w, "%v.%v\t%v\n", schema.Target, table, rows)
+				numOfRows += rows
+			}
+			numOfTables++
+		}
+	}
+	w.Flush()
+
+	if numOfTables == 0 {
+		fmt.Println("no existing target tables will be dropped")
+	} else {
+		fmt.Printf("%v tables and about %v rows in total\n\n", numOfTables, numOfRows)
+	}
+
+	if yes {
+		return nil
+	}
+
+	database := targetDbConn.Config().Database
+	if !askToType("replacing a database is permanent and will remove all data. type the name of the target database to confirm", database) {
+		return ErrAborted
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+// querier is anything rows can be read from, a connection or a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// tableRef points at a single table of a schema mapping
+type tableRef struct {
+	Schema SchemaMapping
+	Table  string
+}
+
+func CopyDataMethod(targetDbConn, sourceDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter, subset Subset, masker *Masker, yes bool) error {
+	// copies every row of the source tables into the matching target tables.
+	// the target tables must already exist and ARE EMPTIED FIRST
+	// with a subset only the root rows and everything they reference is copied
+
+	fmt.Println("source: " + sourceDbConn.Config().Host)
+	fmt.Println("target: " + targetDbConn.Config().Host)
+	if !yes && !askYesNo("copying data will remove all existing rows in the target tables. are you sure?") {
+		return ErrAborted
+	}
+
+	startTime := time.Now()
+	spinner.Start()
+
+	spinner.Suffix = " getting table details"
+
+	sourceTableStructures := make(map[SchemaMapping]map[string]Table)
+	for _, schema := range schemas {
+		sourceTables, err := getSchemaDetails(sourceDbConn, ctx, spinner, schema.Source, true, filter)
+		if err != nil {
+			fmt.Println("error while getting source table schema")
+			return err
+		}
+
+		targetTables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, false, filter)
+		if err != nil {
+			fmt.Println("error while getting target table schema")
+			return err
+		}
+
+		// only tables that exist on both sides can be copied, and only the
+		// columns they have in common
+		tables := make(map[string]Table)
+		for name, sourceTable := range sourceTables {
+			targetTable, exists := targetTables[name]
+			if !exists {
+				spinner.Stop()
+				fmt.Printf("skipping %v.%v, it does not exist in the target\n", schema.Target, name)
+				spinner.Start()
+				continue
+			}
+
+			targetCols := columnNames(targetTable.Columns)
+			var columns []Column
+			for _, col := range sourceTable.Columns {
+				for _, targetCol := range targetCols {
+					if col.ColumnName == targetCol {
+						columns = append(columns, col)
+						break
+					}
+				}
+			}
+
+			sourceTable.Columns = columns
+			tables[name] = sourceTable
+		}
+		sourceTableStructures[schema] = tables
+	}
+
+	tx, err := targetDbConn.Begin(ctx)
+	if err != nil {
+		fmt.Println("error while beginning transaction")
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// only helps with foreign keys that were declared deferrable, the load
+	// order below takes care of the rest
+	if _, err := tx.Exec(ctx, "SET CONSTRAINTS ALL DEFERRED;"); err != nil {
+		fmt.Println("error while deferring constraints")
+		return err
+	}
+
+	order := dependencyOrder(sourceTableStructures, schemas)
+
+	var queries map[tableRef]string
+	copyOrder := order
+	if subset.Enabled() {
+		root, err := resolveSubsetRoot(subset, sourceTableStructures, schemas)
+		if err != nil {
+			return err
+		}
+
+		queries = subsetQueries(root, subset.Where, sourceTableStructures, schemas, order)
+
+		copyOrder = nil
+		for _, ref := range order {
+			if _, needed := queries[ref]; needed {
+				copyOrder = append(copyOrder, ref)
+			}
+		}
+	}
+
+	// tables a subset leaves out are still part of the run, rules for them
+	// are not a typo
+	if err := masker.checkTables(sourceTableStructures, order); err != nil {
+		return err
+	}
+
+	if len(order) > 0 {
+		spinner.Suffix = " emptying target tables"
+
+		var targets []string
+		for _, ref := range order {
+			targets = append(targets, quoteIdentifier(ref.Schema.Target, ref.Table))
+		}
+		if _, err := tx.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s;", strings.Join(targets, ", "))); err != nil {
+			fmt.Println("error while emptying target tables")
+			return err
+		}
+	}
+
+	// every target table is emptied above, even with a subset, so the target
+	// never ends up with rows pointing at rows that are gone
+	// read every table from the same snapshot so rows referenced across
+	// tables line up even while the source is being written to
+	sourceTx, err := sourceDbConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		fmt.Println("error while beginning source transaction")
+		return err
+	}
+	defer sourceTx.Rollback(ctx)
+
+	numOfRowsCopied, err := copyTables(tx, sourceTx, ctx, spinner, sourceTableStructures, copyOrder, queries, masker)
+	if err != nil {
+		return err
+	}
+
+	// every emptied table, the ones left empty by a subset start over
+	if err := restartSequences(tx, ctx, spinner, order); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		fmt.Println("error while committing transaction")
+		return err
+	}
+	spinner.Stop()
+
+	fmt.Printf("\nCopied %v rows into %v tables in %v seconds\n", numOfRowsCopied, len(copyOrder), time.Since(startTime))
+	return nil
+}
+
+// copyTables streams every table in order from the source into the target
+// transaction and returns the total number of rows copied. tables with a
+// query only get the rows it selects, and every row goes through the masker
+// (if any) on the way
+func copyTables(tx pgx.Tx, source querier, ctx context.Context, spinner *spinner.Spinner, structures map[SchemaMapping]map[string]Table, order []tableRef, queries map[tableRef]string, masker *Masker) (int64, error) {
+	var total int64
+	for _, ref := range order {
+		spinner.Suffix = fmt.Sprintf(" copying rows into table %v.%v", ref.Schema.Target, ref.Table)
+
+		copied, err := copyTableData(tx, source, ctx, ref, structures[ref.Schema][ref.Table].Columns, queries[ref], masker)
+		if err != nil {
+			fmt.Printf("error while copying rows into table %v.%v\n", ref.Schema.Target, ref.Table)
+			return total, err
+		}
+		total += copied
+	}
+	return total, nil
+}
+
+// restartSequences moves the sequences behind the serial and identity columns
+// of the given target tables past the highest value in them, so the next
+// insert does not collide with a copied row
+func restartSequences(tx pgx.Tx, ctx context.Context, spinner *spinner.Spinner, refs []tableRef) error {
+	for _, ref := range refs {
+		spinner.Suffix = fmt.Sprintf(" restarting sequences of table %v.%v", ref.Schema.Target, ref.Table)
+		table := quoteIdentifier(ref.Schema.Target, ref.Table)
+
+		rows, err := tx.Query(ctx, `
+			SELECT attname, pg_get_serial_sequence($1, attname) AS sequence
+			FROM pg_catalog.pg_attribute
+			WHERE attrelid = $1::regclass
+			AND attnum > 0
+			AND NOT attisdropped
+			AND pg_get_serial_sequence($1, attname) IS NOT NULL;
+		`, table)
+		if err != nil {
+			fmt.Printf("error while looking up sequences of table %v.%v\n", ref.Schema.Target, ref.Table)
+			return err
+		}
+
+		sequences, err := pgx.CollectRows(rows, pgx.RowToStructByName[struct {
+			Column   string `db:"attname"`
+			Sequence string `db:"sequence"`
+		}])
+		if err != nil {
+			fmt.Println("error while collecting sequence rows")
+			return err
+		}
+
+		for _, seq := range sequences {
+			var next int64
+			if err := tx.QueryRow(ctx, fmt.Sprintf("SELECT COALESCE(max(%s), 0) + 1 FROM %s;", quoteIdentifier(seq.Column), table)).Scan(&next); err != nil {
+				fmt.Printf("error while reading the highest %v of table %v.%v\n", seq.Column, ref.Schema.Target, ref.Table)
+				return err
+			}
+
+			// pg_get_serial_sequence gives the name already quoted. unlike
+			// setval, ALTER SEQUENCE is undone when the transaction rolls back
+			if _, err := tx.Exec(ctx, fmt.Sprintf("ALTER SEQUENCE %s RESTART WITH %d;", seq.Sequence, next)); err != nil {
+				fmt.Printf("error while restarting sequence %v\n", seq.Sequence)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyTableData streams the rows of a single table with the COPY protocol.
+// the source rows are fed straight into CopyFrom so nothing is buffered
+func copyTableData(tx pgx.Tx, source querier, ctx context.Context, ref tableRef, columns []Column, from string, masker *Masker) (int64, error) {
+	if len(columns) == 0 {
+		return 0, nil
+	}
+
+	names := columnNames(columns)
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, quoteIdentifier(name))
+	}
+
+	// from is a query that selects some of the rows of the table
+	rowSource := quoteIdentifier(ref.Schema.Source, ref.Table)
+	if from != "" {
+		rowSource = "(" + from + ") AS subset"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), rowSource)
+
+	rows, err := source.Query(ctx, query+";")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return tx.CopyFrom(ctx, pgx.Identifier{ref.Schema.Target, ref.Table}, names, masker.wrap(ref, names, rows))
+}
+
+// dependencyOrder sorts tables so that every table comes after the tables its
+// foreign keys point at. tables that are part of a cycle are appended at the
+// end in schema and name order
+func dependencyOrder(structures map[SchemaMapping]map[string]Table, schemas []SchemaMapping) []tableRef {
+	var refs []tableRef
+	for _, schema := range schemas {
+		for _, table := range sortedTableNames(structures[schema]) {
+			refs = append(refs, tableRef{Schema: schema, Table: table})
+		}
+	}
+
+	// number of parents each table is still waiting for, and who waits on whom
+	waitingOn := make(map[tableRef]int)
+	children := make(map[tableRef][]tableRef)
+	for _, ref := range refs {
+		seen := make(map[tableRef]bool)
+		for _, fk := range structures[ref.Schema][ref.Table].ForeignKeys {
+			parent, found := foreignKeyParent(fk, structures, schemas)
+			if !found || parent == ref || seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			waitingOn[ref]++
+			children[parent] = append(children[parent], ref)
+		}
+	}
+
+	var order []tableRef
+	var ready []tableRef
+	for _, ref := range refs {
+		if waitingOn[ref] == 0 {
+			ready = append(ready, ref)
+		}
+	}
+
+	placed := make(map[tableRef]bool)
+	for len(ready) > 0 {
+		ref := ready[0]
+		ready = ready[1:]
+
+		order = append(order, ref)
+		placed[ref] = true
+
+		for _, child := range children[ref] {
+			waitingOn[child]--
+			if waitingOn[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+
+	for _, ref := range refs {
+		if !placed[ref] {
+			order = append(order, ref)
+		}
+	}
+
+	return order
+}
+
+// foreignKeyParent finds the table a foreign key points at, as long as that
+// table is part of this run
+func foreignKeyParent(fk ForeignKey, structures map[SchemaMapping]map[string]Table, schemas []SchemaMapping) (tableRef, bool) {
+	for _, schema := range schemas {
+		if schema.Source != fk.ForeignSchemaName {
+			continue
+		}
+		if _, exists := structures[schema][fk.ForeignTableName]; exists {
+			return tableRef{Schema: schema, Table: fk.ForeignTableName}, true
+		}
+	}
+	return tableRef{}, false
+}
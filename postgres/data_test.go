@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDependencyOrder(t *testing.T) {
+	public := SchemaMapping{Source: "public", Target: "public"}
+	billing := SchemaMapping{Source: "billing", Target: "billing_copy"}
+
+	fk := func(schema, table string) ForeignKey {
+		return ForeignKey{SourceColumn: table + "_id", ForeignSchemaName: schema, ForeignTableName: table, ForeignColumnName: "id"}
+	}
+
+	tests := []struct {
+		name       string
+		structures map[SchemaMapping]map[string]Table
+		schemas    []SchemaMapping
+		want       []tableRef
+	}{
+		{
+			name: "parents first",
+			structures: map[SchemaMapping]map[string]Table{public: {
+				"accounts": {},
+				"orders":   {ForeignKeys: []ForeignKey{fk("public", "users"), fk("public", "products")}},
+				"products": {},
+				"users":    {ForeignKeys: []ForeignKey{fk("public", "accounts")}},
+			}},
+			schemas: []SchemaMapping{public},
+			want: []tableRef{
+				{Schema: public, Table: "accounts"},
+				{Schema: public, Table: "products"},
+				{Schema: public, Table: "users"},
+				{Schema: public, Table: "orders"},
+			},
+		},
+		{
+			name: "self references and tables outside the run are ignored",
+			structures: map[SchemaMapping]map[string]Table{public: {
+				"categories": {ForeignKeys: []ForeignKey{fk("public", "categories"), fk("other", "things")}},
+			}},
+			schemas: []SchemaMapping{public},
+			want:    []tableRef{{Schema: public, Table: "categories"}},
+		},
+		{
+			name: "across schemas by source name",
+			structures: map[SchemaMapping]map[string]Table{
+				public:  {"invoices": {ForeignKeys: []ForeignKey{fk("billing", "customers")}}},
+				billing: {"customers": {}},
+			},
+			schemas: []SchemaMapping{public, billing},
+			want: []tableRef{
+				{Schema: billing, Table: "customers"},
+				{Schema: public, Table: "invoices"},
+			},
+		},
+		{
+			name: "cycles go last",
+			structures: map[SchemaMapping]map[string]Table{public: {
+				"a":    {ForeignKeys: []ForeignKey{fk("public", "b")}},
+				"b":    {ForeignKeys: []ForeignKey{fk("public", "a")}},
+				"solo": {},
+			}},
+			schemas: []SchemaMapping{public},
+			want: []tableRef{
+				{Schema: public, Table: "solo"},
+				{Schema: public, Table: "a"},
+				{Schema: public, Table: "b"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dependencyOrder(tt.structures, tt.schemas); !slices.Equal(got, tt.want) {
+				t.Errorf("dependencyOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
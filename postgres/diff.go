@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"slices"
+	"sort"
+)
+
+// DiffReport is everything a diff run found, grouped by schema
+type DiffReport struct {
+	Schemas []SchemaDiff `json:"schemas"`
+}
+
+// SchemaDiff is the structured result of comparing a source schema against a
+// target schema. every report format is rendered from this
+type SchemaDiff struct {
+	SourceSchema  string               `json:"source_schema"`
+	TargetSchema  string               `json:"target_schema"`
+	NewTables     []string             `json:"new_tables"`
+	RemovedTables []string             `json:"removed_tables"`
+	ColumnChanges []TableColumnChanges `json:"column_changes"`
+	// every step that would bring the target in line, classified by safety
+	Changes []Change `json:"changes"`
+}
+
+// TableColumnChanges holds the added and removed columns of a table that
+// exists in both the source and the target
+type TableColumnChanges struct {
+	Table          string   `json:"table"`
+	AddedColumns   []string `json:"added_columns"`
+	RemovedColumns []string `json:"removed_columns"`
+}
+
+func (d SchemaDiff) NumOfColumnChanges() int {
+	total := 0
+	for _, c := range d.ColumnChanges {
+		total += len(c.AddedColumns) + len(c.RemovedColumns)
+	}
+	return total
+}
+
+func (d SchemaDiff) HasChanges() bool {
+	return len(d.NewTables) > 0 || len(d.RemovedTables) > 0 || len(d.ColumnChanges) > 0 || len(d.Changes) > 0
+}
+
+func (d SchemaDiff) NumOfChanges(safety Safety) int {
+	return countChanges(d.Changes, safety)
+}
+
+func buildSchemaDiff(sourceTables, targetTables map[string]Table, sourceSchema, targetSchema string) SchemaDiff {
+	diff := SchemaDiff{
+		SourceSchema:  diffSchemaName(sourceSchema),
+		TargetSchema:  diffSchemaName(targetSchema),
+		NewTables:     []string{},
+		RemovedTables: []string{},
+		ColumnChanges: []TableColumnChanges{},
+		Changes:       []Change{},
+	}
+
+	// new tables (exists in source but not target)
+	for _, table := range sortedTableNames(sourceTables) {
+		if _, exists := targetTables[table]; !exists {
+			diff.NewTables = append(diff.NewTables, table)
+		}
+	}
+
+	// removed tables (exists in target but not source)
+	for _, table := range sortedTableNames(targetTables) {
+		if _, exists := sourceTables[table]; !exists {
+			diff.RemovedTables = append(diff.RemovedTables, table)
+		}
+	}
+
+	// find new and removed columns for each table that exists on both sides
+	for _, table := range sortedTableNames(sourceTables) {
+		targetTable, exists := targetTables[table]
+		if !exists {
+			continue
+		}
+
+		sourceCols := columnNames(sourceTables[table].Columns)
+		targetCols := columnNames(targetTable.Columns)
+
+		changes := TableColumnChanges{Table: table, AddedColumns: []string{}, RemovedColumns: []string{}}
+
+		// addedCols (exists in source but not target)
+		for _, col := range sourceCols {
+			if !slices.Contains(targetCols, col) {
+				changes.AddedColumns = append(changes.AddedColumns, col)
+			}
+		}
+		// removedCols (exists in target but not source)
+		for _, col := range targetCols {
+			if !slices.Contains(sourceCols, col) {
+				changes.RemovedColumns = append(changes.RemovedColumns, col)
+			}
+		}
+
+		if len(changes.AddedColumns) > 0 || len(changes.RemovedColumns) > 0 {
+			diff.ColumnChanges = append(diff.ColumnChanges, changes)
+		}
+	}
+
+	return diff
+}
+
+func sortedTableNames(tables map[string]Table) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func columnNames(columns []Column) []string {
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		names = append(names, col.ColumnName)
+	}
+	return names
+}
+
+// schemas left empty on the command line fall back to public
+func diffSchemaName(schema string) string {
+	if schema == "" {
+		return "public"
+	}
+	return schema
+}
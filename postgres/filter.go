@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Filter decides which tables and which kinds of objects a command works on.
+// the zero value lets everything through
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+
+	// the patterns as given, compiled ones can not be saved and compared
+	settings FilterSettings
+
+	SkipPrimaryKeys bool
+	SkipForeignKeys bool
+}
+
+// FilterSettings is what a filter was made from
+type FilterSettings struct {
+	Include         []string `json:"include,omitempty"`
+	Exclude         []string `json:"exclude,omitempty"`
+	SkipPrimaryKeys bool     `json:"skip_primary_keys,omitempty"`
+	SkipForeignKeys bool     `json:"skip_foreign_keys,omitempty"`
+}
+
+func (s FilterSettings) Equal(other FilterSettings) bool {
+	return slices.Equal(s.Include, other.Include) && slices.Equal(s.Exclude, other.Exclude) &&
+		s.SkipPrimaryKeys == other.SkipPrimaryKeys && s.SkipForeignKeys == other.SkipForeignKeys
+}
+
+// flags gives the settings back as the command line flags they came from
+func (s FilterSettings) flags() string {
+	var flags []string
+	if len(s.Include) > 0 {
+		flags = append(flags, fmt.Sprintf("--include '%v'", strings.Join(s.Include, ",")))
+	}
+	if len(s.Exclude) > 0 {
+		flags = append(flags, fmt.Sprintf("--exclude '%v'", strings.Join(s.Exclude, ",")))
+	}
+	if s.SkipPrimaryKeys {
+		flags = append(flags, "--skip-pks")
+	}
+	if s.SkipForeignKeys {
+		flags = append(flags, "--skip-fks")
+	}
+	if len(flags) == 0 {
+		return "no table filters"
+	}
+	return strings.Join(flags, " ")
+}
+
+func (f Filter) Settings() FilterSettings {
+	settings := f.settings
+	settings.SkipPrimaryKeys = f.SkipPrimaryKeys
+	settings.SkipForeignKeys = f.SkipForeignKeys
+	return settings
+}
+
+// NewFilter compiles include/exclude table patterns. patterns are globs
+// (audit_*, _tmp_?) unless wrapped in slashes, in which case they are
+// treated as regular expressions (/^audit_\d{4}$/)
+func NewFilter(include, exclude []string, skipPrimaryKeys, skipForeignKeys bool) (Filter, error) {
+	filter := Filter{
+		SkipPrimaryKeys: skipPrimaryKeys,
+		SkipForeignKeys: skipForeignKeys,
+	}
+
+	for _, pattern := range include {
+		re, err := compileTablePattern(pattern)
+		if err != nil {
+			return Filter{}, err
+		}
+		filter.include = append(filter.include, re)
+		filter.settings.Include = append(filter.settings.Include, strings.TrimSpace(pattern))
+	}
+
+	for _, pattern := range exclude {
+		re, err := compileTablePattern(pattern)
+		if err != nil {
+			return Filter{}, err
+		}
+		filter.exclude = append(filter.exclude, re)
+		filter.settings.Exclude = append(filter.settings.Exclude, strings.TrimSpace(pattern))
+	}
+
+	return filter, nil
+}
+
+// the tables gograte keeps its own bookkeeping in. they are never part of
+// the schema, so replace does not drop them and diff does not report them
+var internalTables = []string{migrationsTable, auditTable, protectedMarkerTable}
+
+// MatchesTable reports whether a table passes the filter. a table must match
+// at least one include pattern (when any are given) and no exclude pattern
+func (f Filter) MatchesTable(table string) bool {
+	if slices.Contains(internalTables, table) {
+		return false
+	}
+
+	if len(f.include) > 0 {
+		included := false
+		for _, re := range f.include {
+			if re.MatchString(table) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, re := range f.exclude {
+		if re.MatchString(table) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// leavesOut reports whether a foreign key points at a table of one of the
+// mapped schemas that the filter skips. that table is not replaced, so the key
+// can not be added
+func (f Filter) leavesOut(fk ForeignKey, schemas []SchemaMapping) bool {
+	mapped := slices.ContainsFunc(schemas, func(schema SchemaMapping) bool {
+		return schema.Source == fk.ForeignSchemaName
+	})
+	return mapped && !f.MatchesTable(fk.ForeignTableName)
+}
+
+func compileTablePattern(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimSpace(pattern)
+
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid table pattern '%v': %w", pattern, err)
+		}
+		return re, nil
+	}
+
+	// glob -> anchored regex
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,103 @@
+package postgres
+
+import "testing"
+
+func TestCompileTablePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		misses  []string
+		wantErr bool
+	}{
+		{pattern: "users", matches: []string{"users"}, misses: []string{"users_old", "old_users"}},
+		{pattern: "audit_*", matches: []string{"audit_", "audit_2024"}, misses: []string{"audit", "my_audit_2024"}},
+		{pattern: "_tmp_?", matches: []string{"_tmp_1"}, misses: []string{"_tmp_", "_tmp_12"}},
+		{pattern: "  orders  ", matches: []string{"orders"}},
+		{pattern: "a.b", matches: []string{"a.b"}, misses: []string{"axb"}},
+		{pattern: `/^audit_\d{4}$/`, matches: []string{"audit_2024"}, misses: []string{"audit_24", "audit_2024_old"}},
+		{pattern: "/log/", matches: []string{"log", "changelog_old"}},
+		{pattern: "/", matches: []string{"/"}, misses: []string{"a"}},
+		{pattern: "/([/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re, err := compileTablePattern(tt.pattern)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("compileTablePattern(%q) did not fail", tt.pattern)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileTablePattern(%q) failed: %v", tt.pattern, err)
+			}
+
+			for _, table := range tt.matches {
+				if !re.MatchString(table) {
+					t.Errorf("%q does not match %q", tt.pattern, table)
+				}
+			}
+			for _, table := range tt.misses {
+				if re.MatchString(table) {
+					t.Errorf("%q matches %q", tt.pattern, table)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchesTable(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		table            string
+		want             bool
+	}{
+		{name: "no patterns", table: "users", want: true},
+		{name: "included", include: []string{"users", "orders"}, table: "orders", want: true},
+		{name: "not included", include: []string{"users"}, table: "orders", want: false},
+		{name: "excluded", exclude: []string{"audit_*"}, table: "audit_2024", want: false},
+		{name: "exclude wins", include: []string{"*"}, exclude: []string{"users"}, table: "users", want: false},
+		{name: "internal table", table: migrationsTable, want: false},
+		{name: "internal table even when included", include: []string{"gograte_*"}, table: auditTable, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter(tt.include, tt.exclude, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filter.MatchesTable(tt.table); got != tt.want {
+				t.Errorf("MatchesTable(%q) = %v, want %v", tt.table, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterLeavesOut(t *testing.T) {
+	schemas := []SchemaMapping{{Source: "app", Target: "app_copy"}}
+	filter, err := NewFilter(nil, []string{"users"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		fk   ForeignKey
+		want bool
+	}{
+		{name: "filtered table", fk: ForeignKey{ForeignSchemaName: "app", ForeignTableName: "users"}, want: true},
+		{name: "copied table", fk: ForeignKey{ForeignSchemaName: "app", ForeignTableName: "orders"}, want: false},
+		{name: "schema that is not mapped", fk: ForeignKey{ForeignSchemaName: "auth", ForeignTableName: "users"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filter.leavesOut(tt.fk, schemas); got != tt.want {
+				t.Errorf("leavesOut(%v.%v) = %v, want %v", tt.fk.ForeignSchemaName, tt.fk.ForeignTableName, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+// Hook is either a sql file, run inside the transaction of the command, or a
+// shell command
+type Hook struct {
+	SQL   string `json:"sql,omitempty"`
+	Shell string `json:"shell,omitempty"`
+}
+
+type HookSet struct {
+	Before []Hook `json:"before,omitempty"`
+	After  []Hook `json:"after,omitempty"`
+}
+
+// Hooks run around replace and sync. the top level ones run around the whole
+// command, the table ones (keyed by table or target_schema.table) around the
+// work on that table
+type Hooks struct {
+	HookSet
+	Tables map[string]HookSet `json:"tables,omitempty"`
+
+	// sql files are relative to the hooks file
+	dir string
+}
+
+// LoadHooks reads a hooks file. an empty path means no hooks
+func LoadHooks(path string) (*Hooks, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading hooks file: %w", err)
+	}
+
+	var hooks Hooks
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("error while parsing hooks file: %w", err)
+	}
+	hooks.dir = filepath.Dir(path)
+
+	sets := map[string]HookSet{"": hooks.HookSet}
+	for table, set := range hooks.Tables {
+		sets[table] = set
+	}
+	for table, set := range sets {
+		for _, hook := range slices.Concat(set.Before, set.After) {
+			if (hook.SQL == "") == (hook.Shell == "") {
+				if table == "" {
+					return nil, fmt.Errorf("every hook needs either sql or shell")
+				}
+				return nil, fmt.Errorf("every hook needs either sql or shell (table %v)", table)
+			}
+		}
+	}
+
+	return &hooks, nil
+}
+
+// hookRun is what a hook gets to know about the run it is part of, shell
+// hooks see it as GOGRATE_* environment variables
+type hookRun struct {
+	command    string
+	targetConn *pgx.Conn
+}
+
+// sql hooks run inside the transaction and are rolled back with it. shell
+// commands can not be, so they run outside of it, once, even when the
+// transaction is tried again after a lock timeout
+func hooksOfKind(hooks []Hook, shell bool) []Hook {
+	var kept []Hook
+	for _, hook := range hooks {
+		if (hook.Shell != "") == shell {
+			kept = append(kept, hook)
+		}
+	}
+	return kept
+}
+
+// runBeforeTransaction runs the shell commands of the top level before hooks
+// and then those of the tables, before the transaction begins
+func (h *Hooks) runBeforeTransaction(ctx context.Context, spinner *spinner.Spinner, run hookRun, tables map[string][]string) error {
+	if h == nil {
+		return nil
+	}
+	if err := h.run(nil, ctx, spinner, run, hooksOfKind(h.Before, true), "", ""); err != nil {
+		return err
+	}
+	return h.runTables(nil, ctx, spinner, run, tables, false)
+}
+
+// runBefore runs the sql of the top level before hooks, first thing in the
+// transaction
+func (h *Hooks) runBefore(tx pgx.Tx, ctx context.Context, spinner *spinner.Spinner, run hookRun) error {
+	if h == nil {
+		return nil
+	}
+	return h.run(tx, ctx, spinner, run, hooksOfKind(h.Before, false), "", "")
+}
+
+// runAfter runs the sql of the top level after hooks, last thing before the
+// transaction commits. their shell commands wait for runAfterCommit
+func (h *Hooks) runAfter(tx pgx.Tx, ctx context.Context, spinner *spinner.Spinner, run hookRun) error {
+	if h == nil {
+		return nil
+	}
+	return h.run(tx, ctx, spinner, run, hooksOfKind(h.After, false), "", "")
+}
+
+// runAfterCommit runs the shell commands of the table after hooks and then
+// those of the top level, so they see the committed result
+func (h *Hooks) runAfterCommit(ctx context.Context, spinner *spinner.Spinner, run hookRun, tables map[string][]string) error {
+	if h == nil {
+		return nil
+	}
+	if err := h.runTables(nil, ctx, spinner, run, tables, true); err != nil {
+		return err
+	}
+	return h.run(nil, ctx, spinner, run, hooksOfKind(h.After, true), "", "")
+}
+
+// runTables runs the before or after hooks of every given table, tables are
+// keyed by schema. with a transaction that is their sql, without one their
+// shell commands
+func (h *Hooks) runTables(tx pgx.Tx, ctx context.Context, spinner *spinner.Spinner, run hookRun, tables map[string][]string, after bool) error {
+	if h == nil || len(h.Tables) == 0 {
+		return nil
+	}
+
+	schemas := make([]string, 0, len(tables))
+	for schema := range tables {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+
+	for _, schema := range schemas {
+		for _, table := range tables[schema] {
+			set, exists := h.Tables[schema+"."+table]
+			if !exists {
+				set, exists = h.Tables[table]
+			}
+			if !exists {
+				continue
+			}
+
+			hooks := set.Before
+			if after {
+				hooks = set.After
+			}
+			hooks = hooksOfKind(hooks, tx == nil)
+			if err := h.run(tx, ctx, spinner, run, hooks, schema, table); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *Hooks) run(tx pgx.Tx, ctx context.Context, spinner *spinner.Spinner, run hookRun, hooks []Hook, schema, table string) error {
+	for _, hook := range hooks {
+		if hook.SQL != "" {
+			file := hook.SQL
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(h.dir, file)
+			}
+			spinner.Suffix = " running hook " + file
+
+			sql, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("error while reading hook: %w", err)
+			}
+
+			// no arguments means the simple protocol, which runs every statement in the file
+			if _, err := tx.Exec(ctx, string(sql)); err != nil {
+				return fmt.Errorf("error in hook %v: %w", file, err)
+			}
+			continue
+		}
+
+		spinner.Suffix = " running hook " + hook.Shell
+
+		config := run.targetConn.Config()
+		cmd := exec.CommandContext(ctx, "sh", "-c", hook.Shell)
+		cmd.Dir = h.dir
+		cmd.Env = append(os.Environ(),
+			"GOGRATE_COMMAND="+run.command,
+			"GOGRATE_TARGET_HOST="+config.Host,
+			fmt.Sprintf("GOGRATE_TARGET_PORT=%v", config.Port),
+			"GOGRATE_TARGET_DATABASE="+config.Database,
+			"GOGRATE_SCHEMA="+schema,
+			"GOGRATE_TABLE="+table,
+		)
+
+		// the spinner would draw over whatever the command prints
+		spinner.Stop()
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		spinner.Start()
+		if err != nil {
+			return fmt.Errorf("error in hook '%v': %w", hook.Shell, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// every gograte run that writes to a database takes this advisory lock, so
+// two of them can not interleave their DDL. "gograte" in ascii
+const advisoryLockKey int64 = 0x67_6f_67_72_61_74_65
+
+// LockTarget takes the gograte advisory lock on the target for as long as the
+// connection lives, or until the returned unlock is called. it does not wait,
+// when someone else holds the lock it says who
+func LockTarget(targetDbConn *pgx.Conn, ctx context.Context) (func(), error) {
+	var locked bool
+	if err := targetDbConn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1);", advisoryLockKey).Scan(&locked); err != nil {
+		fmt.Println("error while taking advisory lock on target")
+		return nil, err
+	}
+
+	if !locked {
+		return nil, fmt.Errorf("another gograte run is already working on this target (%v)", describeLockHolder(targetDbConn, ctx))
+	}
+
+	unlock := func() {
+		targetDbConn.Exec(context.Background(), "SELECT pg_advisory_unlock($1);", advisoryLockKey)
+	}
+	return unlock, nil
+}
+
+// describeLockHolder finds the session holding the lock, best effort
+func describeLockHolder(targetDbConn *pgx.Conn, ctx context.Context) string {
+	var pid int32
+	var user, application, client string
+	var since time.Time
+
+	// a bigint key is split over classid (high half) and objid (low half)
+	err := targetDbConn.QueryRow(ctx, `
+		SELECT a.pid, COALESCE(a.usename, ''), COALESCE(a.application_name, ''), COALESCE(host(a.client_addr), 'local'), a.backend_start
+		FROM pg_locks l
+		JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory'
+			AND l.granted
+			AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+			AND l.classid::bigint = $1
+			AND l.objid::bigint = $2
+			AND l.objsubid = 1
+		LIMIT 1;
+	`, advisoryLockKey>>32, advisoryLockKey&0xffffffff).Scan(&pid, &user, &application, &client, &since)
+	if err != nil {
+		return "could not look up the session holding the lock"
+	}
+
+	return fmt.Sprintf("held by pid %v, user %v, application '%v' from %v, connected since %v", pid, user, application, client, since.Local().Format(time.DateTime))
+}
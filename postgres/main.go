@@ -3,9 +3,9 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net/url"
 	"os"
-	"slices"
 	"strings"
 	"time"
 
@@ -18,25 +18,68 @@ type TablesQueryResponse struct {
 }
 
 type ForeignKey struct {
-	ForeignColumnName string
-	ForeignTableName  string
-	SourceColumn      string
+	ForeignColumnName string `json:"foreign_column"`
+	ForeignTableName  string `json:"foreign_table"`
+	ForeignSchemaName string `json:"foreign_schema"`
+	SourceColumn      string `json:"column"`
 }
 
 type Table struct {
-	PrimaryKey  string
-	ForeignKeys []ForeignKey
-	Columns     []Column
+	PrimaryKey  string       `json:"primary_key,omitempty"`
+	ForeignKeys []ForeignKey `json:"foreign_keys,omitempty"`
+	Columns     []Column     `json:"columns"`
 }
 
 type Column struct {
-	ColumnName    string
-	ColumnType    string
-	Nullable      bool
-	ColumnDefault *string // can be null
+	ColumnName    string  `json:"name"`
+	ColumnType    string  `json:"type"`
+	Nullable      bool    `json:"nullable"`
+	ColumnDefault *string `json:"default,omitempty"` // can be null
 }
 
-func DiffMethod(targetDbConn, sourceDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, targetSchema, sourceSchema string) error {
+// SchemaMapping pairs a schema in the source database with the schema it is
+// mirrored to in the target database
+type SchemaMapping struct {
+	Source string
+	Target string
+}
+
+// ReplaceOptions are the knobs of ReplaceMethod that are not about which
+// schemas or tables to work on
+type ReplaceOptions struct {
+	// carry the owner and grants of a source schema over when its target
+	// schema has to be created
+	CopySchemaPrivileges bool
+
+	// copy every row from the source tables after they are created
+	WithData bool
+	// masks columns of the copied rows, nil copies them as is
+	Masker *Masker
+
+	// write the tables about to be dropped to a sql file in BackupDir first,
+	// with their rows when BackupData is set
+	Backup     bool
+	BackupData bool
+	BackupDir  string
+
+	// skip the prompts, for running without a terminal
+	Yes bool
+
+	// applied to the transaction that drops and recreates the tables
+	Timeouts Timeouts
+
+	// sql files and shell commands to run around the replace, nil runs none
+	Hooks *Hooks
+
+	// commit every table on its own instead of all in one transaction, and
+	// keep track of the committed ones in ProgressFile so a failed run can
+	// be continued with Resume
+	PerTable     bool
+	Resume       bool
+	ProgressFile string
+}
+
+func DiffMethod(target, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, format string, filter Filter, online bool) error {
 	/*
 		showcases between the source and target table:
 		- new tables
@@ -44,326 +87,356 @@ func DiffMethod(targetDbConn, sourceDbConn *pgx.Conn, ctx context.Context, spinn
 		- new columns
 		- removed columns
 		- changes in existing tables (new and removed cols)
+		- the steps to get there, classified as safe, risky or destructive
 	*/
 
-	query := `
-		SELECT
-		t.table_name,
-		c.column_name
-		FROM information_schema.tables t
-		LEFT JOIN information_schema.columns c
-		ON c.table_schema = t.table_schema
-		AND c.table_name   = t.table_name
-		WHERE t.table_schema =  $1
-		ORDER BY t.table_name, c.ordinal_position;
-	`
-
 	spinner.Start()
 	spinner.Suffix = " getting diff"
 
-	sourceTablesQuery, err := sourceDbConn.Query(ctx, query, sourceSchema)
-
-	if err != nil {
-		return fmt.Errorf("%s", "error while querying source databases tables\n"+err.Error())
-	}
-	sourcetables, err := pgx.CollectRows(sourceTablesQuery, pgx.RowToAddrOfStructByName[struct {
-		Tablename  string  `db:"table_name"`
-		ColumnName *string `db:"column_name"`
-	}])
-
-	targetTableQuery, err := targetDbConn.Query(ctx, query, targetSchema)
-
-	if err != nil {
-		return fmt.Errorf("%s", "error while querying target databases tables\n"+err.Error())
-	}
-	targetTables, err := pgx.CollectRows(targetTableQuery, pgx.RowToAddrOfStructByName[struct {
-		Tablename  string  `db:"table_name"`
-		ColumnName *string `db:"column_name"`
-	}])
-
-	// MAP STRUCTURE - KEY: table VALUE: all columns for that table
-	targetDetails := make(map[string][]string)
-	sourceDetails := make(map[string][]string)
-	for _, t := range sourcetables {
-		_, exists := sourceDetails[t.Tablename]
-		if !exists {
-			sourceDetails[t.Tablename] = []string{}
+	var diffs []SchemaDiff
+	for _, schema := range schemas {
+		sourceTables, err := source.getSchemaDetails(ctx, spinner, schema.Source, true, filter)
+		if err != nil {
+			return fmt.Errorf("%s", "error while querying source databases tables\n"+err.Error())
 		}
 
-		if t.ColumnName != nil {
-			cols := sourceDetails[t.Tablename]
-			cols = append(cols, *t.ColumnName)
-			sourceDetails[t.Tablename] = cols
-		}
-	}
-	for _, t := range targetTables {
-		_, exists := targetDetails[t.Tablename]
-		if !exists {
-			targetDetails[t.Tablename] = []string{}
+		targetTables, err := target.getSchemaDetails(ctx, spinner, schema.Target, true, filter)
+		if err != nil {
+			return fmt.Errorf("%s", "error while querying target databases tables\n"+err.Error())
 		}
 
-		if t.ColumnName != nil {
-			cols := targetDetails[t.Tablename]
-			cols = append(cols, *t.ColumnName)
-			targetDetails[t.Tablename] = cols
-		}
+		diff := buildSchemaDiff(sourceTables, targetTables, schema.Source, schema.Target)
+		diff.Changes = append(diff.Changes, planSchemaChanges(sourceTables, targetTables, schema, schemas, online)...)
+		diffs = append(diffs, diff)
 	}
 
-	var newTables []string
-	var removedTables []string
-	var existingTables []string
+	spinner.Stop()
 
-	// new tables / columns
-	// (exists in source but not target)
-	for sourceTable, _ := range sourceDetails {
-		newTable := true
+	return renderDiffReport(os.Stdout, DiffReport{Schemas: diffs}, format)
+}
 
-		for targetTable, _ := range targetDetails {
-			if targetTable == sourceTable {
-				newTable = false
-				break
-			}
-		}
+func ReplaceMethod(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter, options ReplaceOptions) error {
+	// will delete the target db and rebuild based on targets schema
+	// ALL DATA WILL BE LOST
 
-		if newTable {
-			newTables = append(newTables, sourceTable)
-		}
+	// rows and privileges can only come from a live database, not a file
+	var sourceDbConn *pgx.Conn
+	if live, ok := source.(liveSchema); ok {
+		sourceDbConn = live.conn
 	}
-
-	// removed tables / columns
-	// (exists in target but not source)
-	for targetTable, _ := range targetDetails {
-		removedTable := true
-
-		for sourceTable, _ := range sourceDetails {
-			if sourceTable == targetTable {
-				removedTable = false
-				break
-			}
-		}
-
-		if removedTable {
-			removedTables = append(removedTables, targetTable)
-		}
+	if sourceDbConn == nil && options.WithData {
+		return fmt.Errorf("--with-data needs a live source database, not a file")
+	}
+	if sourceDbConn == nil && options.CopySchemaPrivileges {
+		return fmt.Errorf("--copy-schema-privileges needs a live source database, not a file")
+	}
+	if options.Hooks != nil && (options.PerTable || options.Resume) {
+		return fmt.Errorf("--hooks can not be combined with --per-table or --resume, hooks need the single transaction")
 	}
 
-	// existing tables
-	// (tables that are not in either newTables or removedTables slices)
-	for table := range sourceDetails {
-		isExistingTable := true
-
-		for _, t := range append(newTables, removedTables...) {
-			if t == table {
-				isExistingTable = false
-				break
-			}
+	// a per table replace that failed carries on where it stopped, it was
+	// confirmed and backed up when it started
+	if options.Resume {
+		progress, err := resumeReplace(targetDbConn, source, ctx, spinner, filter, options)
+		if err != nil {
+			return err
 		}
+		return runReplaceSteps(targetDbConn, source, ctx, spinner, filter, progress, options)
+	}
 
-		if isExistingTable {
-			existingTables = append(existingTables, table)
-		}
+	// a progress file that is already there belongs to a run that did not
+	// finish, it is never overwritten
+	if _, err := os.Stat(options.ProgressFile); options.PerTable && err == nil {
+		return fmt.Errorf("%v is left from a replace that did not finish, continue it with --resume or delete the file", options.ProgressFile)
 	}
 
-	// find new and removed columns for each existing table
-	existingTableDiffs := make(map[string]struct {
-		addedCols   []string
-		removedCols []string
-	})
+	if err := confirmReplace(targetDbConn, ctx, spinner, source, schemas, filter, options.Yes); err != nil {
+		return err
+	}
 
-	for _, table := range existingTables {
-		existingTableDiffs[table] = struct {
-			addedCols   []string
-			removedCols []string
-		}{}
-
-		// addedCols (exists in source but not target)
-		for _, col := range sourceDetails[table] {
-			if !slices.Contains(targetDetails[table], col) {
-				diff := existingTableDiffs[table]
-				diff.addedCols = append(diff.addedCols, col)
-				existingTableDiffs[table] = diff
-			}
-		}
-		// removedCols (exists in target but not source)
-		for _, col := range targetDetails[table] {
-			if !slices.Contains(sourceDetails[table], col) {
-				diff := existingTableDiffs[table]
-				diff.removedCols = append(diff.removedCols, col)
-				existingTableDiffs[table] = diff
-			}
+	// tables can only be created in schemas that exist, offer to create the
+	// missing ones as part of the replace
+	missingSchemas, err := missingTargetSchemas(targetDbConn, ctx, schemas)
+	if err != nil {
+		return err
+	}
+	for _, schema := range missingSchemas {
+		if options.Yes {
+			fmt.Printf("target schema %v does not exist, creating it\n", schema.Target)
+		} else if !askYesNo(fmt.Sprintf("target schema %v does not exist. create it?", schema.Target)) {
+			return fmt.Errorf("target schema '%v' does not exist", schema.Target)
 		}
 	}
 
-	spinner.Stop()
+	startTime := time.Now()
+	spinner.Start()
 
-	fmt.Printf("new tables (%v):\n", len(newTables))
-	if len(newTables) > 0 {
-		for _, table := range newTables {
-			fmt.Printf("\t+ %s\n", table)
+	if options.Backup {
+		path, err := backupTarget(targetDbConn, ctx, spinner, schemas, filter, options.BackupDir, options.BackupData)
+		if err != nil {
+			spinner.Stop()
+			fmt.Println("error while backing up target, nothing was changed")
+			return err
 		}
-	} else {
-		fmt.Println("  none")
+
+		spinner.Stop()
+		config := targetDbConn.Config()
+		fmt.Println("target backed up to " + path)
+		fmt.Printf("restore it with: psql -h %v -p %v -U %v -d %v -f %v\n", config.Host, config.Port, config.User, config.Database, path)
+		spinner.Start()
 	}
 
-	fmt.Printf("\ndeleted tables (%v):\n", len(removedTables))
-	if len(removedTables) > 0 {
-		for _, table := range removedTables {
-			fmt.Printf("\t- %s\n", table)
+	if options.PerTable {
+		progress, err := startReplacePerTable(targetDbConn, source, ctx, spinner, schemas, missingSchemas, filter, options)
+		spinner.Stop()
+		if err != nil {
+			return err
 		}
-	} else {
-		fmt.Println("  none")
+		return runReplaceSteps(targetDbConn, source, ctx, spinner, filter, progress, options)
 	}
 
-	numOfExistingTableColChanges := 0
-	for _, v := range existingTableDiffs {
-		numOfExistingTableColChanges += len(v.addedCols)
-		numOfExistingTableColChanges += len(v.removedCols)
+	numOfTablesCreated := 0
+	numOfColumnsCreated := 0
+	var numOfRowsCopied int64
+
+	// read every table from the same snapshot so rows referenced across
+	// tables line up even while the source is being written to. the table
+	// details are read through it as well, so they match the rows
+	var sourceRows querier = sourceDbConn
+	if options.WithData {
+		sourceTx, err := sourceDbConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+		if err != nil {
+			spinner.Stop()
+			fmt.Println("error while beginning source transaction")
+			return err
+		}
+		defer sourceTx.Rollback(ctx)
+		sourceRows = sourceTx
 	}
 
-	fmt.Printf("\ncolumn changes (%v):\n", numOfExistingTableColChanges)
-	hasColumnChanges := false
-	for _, table := range existingTables {
-		diff := existingTableDiffs[table]
-		if len(diff.addedCols) > 0 || len(diff.removedCols) > 0 {
-			hasColumnChanges = true
-			fmt.Printf("  %s:\n", table)
-			for _, col := range diff.addedCols {
-				fmt.Printf("    + %s\n", col)
+	// every table that is dropped, created or both, for the table hooks. the
+	// target lock keeps other runs from changing it in the meantime
+	replacedTables := make(map[string][]string)
+	if options.Hooks != nil {
+		spinner.Suffix = " getting table details"
+		for _, schema := range schemas {
+			sourceTables, err := source.getSchemaDetails(ctx, spinner, schema.Source, false, filter)
+			if err != nil {
+				spinner.Stop()
+				fmt.Println("error while getting source table schema")
+				return err
 			}
-			for _, col := range diff.removedCols {
-				fmt.Printf("    - %s\n", col)
+			targetTables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, false, filter)
+			if err != nil {
+				spinner.Stop()
+				fmt.Println("error while getting target table schema")
+				return err
 			}
+
+			all := make(map[string]Table)
+			maps.Copy(all, targetTables)
+			maps.Copy(all, sourceTables)
+			replacedTables[schema.Target] = sortedTableNames(all)
 		}
 	}
-	if !hasColumnChanges {
-		fmt.Println("  none")
-	}
-
-	return nil
-}
-
-func ReplaceMethod(targetDbConn, sourceDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, sourceSchema, targetSchema string) error {
-	// will delete the target db and rebuild based on targets schema
-	// ALL DATA WILL BE LOST
 
-	var yesno string
-	for {
-		fmt.Println("source: " + sourceDbConn.Config().Host)
-		fmt.Println("target: " + targetDbConn.Config().Host)
-		fmt.Print("replacing a database is permanent and will remove all data. are you sure? (y/n): ")
+	if err := options.Hooks.runBeforeTransaction(ctx, spinner, hookRun{command: "replace", targetConn: targetDbConn}, replacedTables); err != nil {
+		spinner.Stop()
+		return err
+	}
 
-		fmt.Scan(&yesno)
+	// running into the lock timeout rolls everything back, so the whole
+	// transaction is tried again
+	err = options.Timeouts.retryOnLockTimeout(spinner, func() error {
+		spinner.Suffix = " getting table details"
 
-		answer := strings.TrimSpace(strings.ToLower(yesno))
+		// WRAP EVERYTHING IN A TRANSACTION TO PREVENT THE WORST!
+		tx, err := targetDbConn.Begin(ctx)
+		if err != nil {
+			fmt.Println("error while beginning transaction")
+			return err
+		}
+		defer tx.Rollback(ctx) // rollback if we dont commit!!!!!!
 
-		if answer != "y" && answer != "n" {
-			continue
+		if err := options.Timeouts.apply(tx, ctx); err != nil {
+			return err
 		}
 
-		if answer == "n" {
-			os.Exit(0)
-		} else if answer == "y" {
-			break
-		} else {
-			continue
+		if err := options.Hooks.runBefore(tx, ctx, spinner, hookRun{command: "replace", targetConn: targetDbConn}); err != nil {
+			return err
 		}
-	}
 
-	startTime := time.Now()
-	spinner.Start()
+		for _, schema := range missingSchemas {
+			spinner.Suffix = " creating schema " + schema.Target
 
-	spinner.Suffix = " getting table details"
+			queries, err := generateCreateSchemaQueries(sourceDbConn, ctx, schema, options.CopySchemaPrivileges)
+			if err != nil {
+				return err
+			}
+			for _, query := range queries {
+				if _, err := tx.Exec(ctx, query); err != nil {
+					fmt.Println("error while creating target schema " + schema.Target)
+					return err
+				}
+			}
+		}
 
-	// WRAP EVERYTHING IN A TRANSACTION TO PREVENT THE WORST!
-	tx, err := targetDbConn.Begin(ctx)
-	if err != nil {
-		fmt.Println("error while beginning transaction")
-		return err
-	}
-	defer tx.Rollback(ctx) // rollback if we dont commit!!!!!!
+		// source schema name -> target schema name, used to point foreign keys
+		// that cross schemas at the right place in the target
+		targetSchemaFor := make(map[string]string)
+		for _, schema := range schemas {
+			targetSchemaFor[schema.Source] = schema.Target
+		}
 
-	sourceTableStructures, err := getSchemaDetails(sourceDbConn, ctx, spinner, sourceSchema, true)
-	if err != nil {
-		fmt.Println("error while getting source table schema")
-		return err
-	}
+		sourceTableStructures := make(map[SchemaMapping]map[string]Table)
+		targetTableStructures := make(map[SchemaMapping]map[string]Table)
+		for _, schema := range schemas {
+			sourceTables, err := source.getSchemaDetails(ctx, spinner, schema.Source, true, filter)
+			if err != nil {
+				fmt.Println("error while getting source table schema")
+				return err
+			}
+			sourceTableStructures[schema] = sourceTables
 
-	targetTableStructures, err := getSchemaDetails(targetDbConn, ctx, spinner, targetSchema, false)
-	if err != nil {
-		fmt.Println("error while getting target table schema")
-		return err
-	}
+			targetTables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, false, filter)
+			if err != nil {
+				fmt.Println("error while getting target table schema")
+				return err
+			}
+			targetTableStructures[schema] = targetTables
+		}
 
-	// delete all tables in the target db before creating tables
-	for key := range targetTableStructures {
-		spinner.Suffix = fmt.Sprintf(" deleting table %v", key)
+		if options.WithData {
+			if err := options.Masker.checkTables(sourceTableStructures, dependencyOrder(sourceTableStructures, schemas)); err != nil {
+				return err
+			}
+		}
 
-		_, err := tx.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %v CASCADE;", key))
-		if err != nil {
-			fmt.Println("error while deleting target table")
+		if err := options.Hooks.runTables(tx, ctx, spinner, hookRun{command: "replace", targetConn: targetDbConn}, replacedTables, false); err != nil {
 			return err
 		}
-	}
 
-	// generate a create table query for every table detected in source db
-	// add all columns with tables as well
-	numOfTablesCreated := 0
-	numOfColumnsCreated := 0
-	for key, value := range sourceTableStructures {
-		spinner.Suffix = fmt.Sprintf(" creating table %v", key)
+		// delete all tables in the target db before creating tables
+		for _, schema := range schemas {
+			for key := range targetTableStructures[schema] {
+				spinner.Suffix = fmt.Sprintf(" deleting table %v.%v", schema.Target, key)
 
-		_, err = tx.Exec(ctx, generateCreateTableQuery(key, value.Columns))
-		if err != nil {
-			fmt.Println("error while creating table")
-			return err
+				_, err := tx.Exec(ctx, generateDropTableQuery(schema.Target, key))
+				if err != nil {
+					fmt.Println("error while deleting target table")
+					return err
+				}
+			}
 		}
-		numOfTablesCreated++
-		numOfColumnsCreated += len(value.Columns)
-	}
 
-	// INSERT ALL PKS BEFORE FKS BELOW!!!!!!!!!!!!!!
-	for table, tableDetails := range sourceTableStructures {
-		spinner.Suffix = " adding constraints to table " + table
+		// generate a create table query for every table detected in source db
+		// add all columns with tables as well
+		numOfTablesCreated = 0
+		numOfColumnsCreated = 0
+		for _, schema := range schemas {
+			for key, value := range sourceTableStructures[schema] {
+				spinner.Suffix = fmt.Sprintf(" creating table %v.%v", schema.Target, key)
+
+				_, err = tx.Exec(ctx, generateCreateTableWithDefaultsQuery(schema.Target, key, value.Columns))
+				if err != nil {
+					fmt.Println("error while creating table")
+					return err
+				}
+				numOfTablesCreated++
+				numOfColumnsCreated += len(value.Columns)
+			}
+		}
 
-		// insert pk
-		if tableDetails.PrimaryKey != "" {
-			_, err = tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s);", table, tableDetails.PrimaryKey))
+		// load the data before any constraints exist, so the order doesnt matter
+		// and there are no indexes to maintain while copying
+		numOfRowsCopied = 0
+		if options.WithData {
+			order := dependencyOrder(sourceTableStructures, schemas)
+			numOfRowsCopied, err = copyTables(tx, sourceRows, ctx, spinner, sourceTableStructures, order, nil, options.Masker)
 			if err != nil {
-				fmt.Println("error while adding primary key to table " + table + " with value " + tableDetails.PrimaryKey)
+				return err
+			}
+			if err := restartSequences(tx, ctx, spinner, order); err != nil {
 				return err
 			}
 		}
 
-	}
+		// INSERT ALL PKS BEFORE FKS BELOW!!!!!!!!!!!!!!
+		for _, schema := range schemas {
+			for table, tableDetails := range sourceTableStructures[schema] {
+				spinner.Suffix = fmt.Sprintf(" adding constraints to table %v.%v", schema.Target, table)
+
+				// insert pk
+				if tableDetails.PrimaryKey != "" {
+					_, err = tx.Exec(ctx, generatePrimaryKeyQuery(schema.Target, table, tableDetails.PrimaryKey))
+					if err != nil {
+						fmt.Println("error while adding primary key to table " + table + " with value " + tableDetails.PrimaryKey)
+						return err
+					}
+				}
+			}
+		}
 
-	// insert all foreign keys
-	for table, tableDetails := range sourceTableStructures {
-		spinner.Suffix = " adding constraints to table " + table
-		for _, fk := range tableDetails.ForeignKeys {
-			// insert fk
-			_, err = tx.Exec(ctx, fmt.Sprintf(`
-			ALTER TABLE %s
-			ADD FOREIGN KEY (%s)
-			REFERENCES %s(%s);
-		`, table, fk.SourceColumn, fk.ForeignTableName, fk.ForeignColumnName))
-			if err != nil {
-				fmt.Printf("error while adding fk key to table %s: column %s referencing %s(%s)\n", table, fk.SourceColumn, fk.ForeignTableName, fk.ForeignColumnName)
-				return err
+		// insert all foreign keys
+		for _, schema := range schemas {
+			for table, tableDetails := range sourceTableStructures[schema] {
+				spinner.Suffix = fmt.Sprintf(" adding constraints to table %v.%v", schema.Target, table)
+				for _, fk := range tableDetails.ForeignKeys {
+					if filter.leavesOut(fk, schemas) {
+						fmt.Printf("warning: skipping foreign key on %v.%v(%v), it references %v.%v which is filtered out\n", schema.Target, table, fk.SourceColumn, fk.ForeignSchemaName, fk.ForeignTableName)
+						continue
+					}
+
+					// references to schemas that are not part of this run are left as is
+					foreignSchema, mapped := targetSchemaFor[fk.ForeignSchemaName]
+					if !mapped {
+						foreignSchema = fk.ForeignSchemaName
+					}
+
+					// insert fk
+					_, err = tx.Exec(ctx, generateForeignKeyQuery(schema.Target, table, foreignSchema, fk))
+					if err != nil {
+						fmt.Printf("error while adding fk key to table %s: column %s referencing %s.%s(%s)\n", table, fk.SourceColumn, foreignSchema, fk.ForeignTableName, fk.ForeignColumnName)
+						return err
+					}
+				}
 			}
 		}
+
+		if err := options.Hooks.runTables(tx, ctx, spinner, hookRun{command: "replace", targetConn: targetDbConn}, replacedTables, true); err != nil {
+			return err
+		}
+		if err := options.Hooks.runAfter(tx, ctx, spinner, hookRun{command: "replace", targetConn: targetDbConn}); err != nil {
+			return err
+		}
+
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		return err
 	}
 
-	tx.Commit(ctx)
+	if err := options.Hooks.runAfterCommit(ctx, spinner, hookRun{command: "replace", targetConn: targetDbConn}, replacedTables); err != nil {
+		spinner.Stop()
+		fmt.Println("the replace was committed, but an after hook failed")
+		return err
+	}
 	spinner.Stop()
 
-	fmt.Printf("\nReplaced %v tables and %v columns in %v seconds\n", numOfTablesCreated, numOfColumnsCreated, time.Since(startTime))
+	if options.WithData {
+		fmt.Printf("\nReplaced %v tables, %v columns and %v rows in %v seconds\n", numOfTablesCreated, numOfColumnsCreated, numOfRowsCopied, time.Since(startTime))
+	} else {
+		fmt.Printf("\nReplaced %v tables and %v columns in %v seconds\n", numOfTablesCreated, numOfColumnsCreated, time.Since(startTime))
+	}
 	return nil
 }
 
-func ConnectToPostgres(host, database, user, password, port, schema string) (*pgx.Conn, error) {
-	if host == "" || database == "" || user == "" {
-		fmt.Println("must supply a host, database, and user")
-		return nil, fmt.Errorf("must supply a host, database, and user")
+// ConnectToPostgres connects to a database. tracer, when not nil, sees every
+// statement sent over the connection
+func ConnectToPostgres(host, database, user, password, port, schema string, tracer pgx.QueryTracer) (*pgx.Conn, error) {
+	if host == "" || port == "" || database == "" || user == "" {
+		fmt.Println("must supply a host, port, database, and user")
+		return nil, fmt.Errorf("must supply a host, port, database, and user")
 	}
 	var connectionString string
 
@@ -382,6 +455,11 @@ func ConnectToPostgres(host, database, user, password, port, schema string) (*pg
 
 	connectionConfig.ConnectTimeout = 10 * time.Second // 10 second timeout
 
+	// lets other sessions see who is connected, e.g. who holds the gograte lock
+	hostname, _ := os.Hostname()
+	connectionConfig.RuntimeParams["application_name"] = "gograte@" + hostname
+	connectionConfig.Tracer = tracer
+
 	ctx := context.Background()
 	conn, err := pgx.ConnectConfig(ctx, connectionConfig)
 	if err != nil {
@@ -392,7 +470,7 @@ func ConnectToPostgres(host, database, user, password, port, schema string) (*pg
 	return conn, nil
 }
 
-func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, schema string, getConstraints bool) (map[string]Table, error) {
+func getSchemaDetails(dbConn querier, ctx context.Context, spinner *spinner.Spinner, schema string, getConstraints bool, filter Filter) (map[string]Table, error) {
 	/*
 		this function is fukin insane...
 		basically get all the tables inside this schema along with their constraints
@@ -409,12 +487,12 @@ func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Sp
 
 	// first, get only the tables
 	// this will return all tables regardless or not if it has any columns
-	databaseTablesQuery, err := dbConn.Query(ctx, fmt.Sprintf(`
+	databaseTablesQuery, err := dbConn.Query(ctx, `
 		SELECT table_name
 		FROM information_schema.tables
-		WHERE table_schema = '%v'
+		WHERE table_schema = $1
 		ORDER BY table_name;
-	`, schema))
+	`, schema)
 
 	if err != nil {
 		fmt.Println("error while querying source databases tables")
@@ -430,6 +508,10 @@ func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Sp
 	}
 
 	for _, t := range databaseTables {
+		if !filter.MatchesTable(t.Tablename) {
+			continue
+		}
+
 		_, exists := tables[t.Tablename]
 
 		if !exists {
@@ -441,19 +523,22 @@ func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Sp
 	spinner.Suffix = " loading columns"
 
 	// now get all the columns
+	// format_type gives the type the way it is written in a CREATE TABLE, with
+	// its length or precision, arrays as type[] and enums and domains by name
+	// instead of USER-DEFINED
 	databaseTablesColumnsQuery, err := dbConn.Query(ctx, `
 		SELECT
-			table_name,
-			column_name,
-			CASE
-				WHEN data_type = 'ARRAY' THEN REPLACE(udt_name, '_', '') || '[]'
-				ELSE data_type
-			END as data_type,
-			is_nullable,
-			column_default
-		FROM information_schema.columns
-		WHERE table_schema = $1
-		ORDER BY table_name, ordinal_position;
+			c.table_name,
+			c.column_name,
+			format_type(a.atttypid, a.atttypmod) AS data_type,
+			c.is_nullable,
+			c.column_default
+		FROM information_schema.columns c
+		JOIN pg_catalog.pg_namespace n ON n.nspname = c.table_schema
+		JOIN pg_catalog.pg_class t ON t.relnamespace = n.oid AND t.relname = c.table_name
+		JOIN pg_catalog.pg_attribute a ON a.attrelid = t.oid AND a.attname = c.column_name
+		WHERE c.table_schema = $1
+		ORDER BY c.table_name, c.ordinal_position;
 	`, schema)
 
 	if err != nil {
@@ -474,6 +559,10 @@ func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Sp
 	}
 
 	for _, dt := range databaseTablesColumns {
+		if _, included := tables[dt.Tablename]; !included {
+			continue
+		}
+
 		var isNullable bool
 		if dt.Nullable == "YES" {
 			isNullable = true
@@ -502,6 +591,7 @@ func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Sp
 					tc.constraint_name,
 					tc.constraint_type,
 					kcu.column_name,
+					ccu.table_schema AS foreign_table_schema,
 					ccu.table_name  AS foreign_table_name,
 					ccu.column_name AS foreign_column_name
 					FROM information_schema.table_constraints tc
@@ -509,8 +599,8 @@ func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Sp
 					ON tc.constraint_name = kcu.constraint_name
 					AND tc.table_schema   = kcu.table_schema
 					LEFT JOIN information_schema.constraint_column_usage ccu
-					ON tc.constraint_name = ccu.constraint_name
-					AND tc.table_schema   = ccu.table_schema
+					ON tc.constraint_name   = ccu.constraint_name
+					AND tc.constraint_schema = ccu.constraint_schema
 					WHERE tc.table_schema = $1
 					AND tc.constraint_type IN ('PRIMARY KEY', 'FOREIGN KEY')
 					ORDER BY tc.table_name, tc.constraint_type, kcu.ordinal_position;
@@ -528,23 +618,27 @@ func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Sp
 			SourceColumnName  string `db:"column_name"`
 			ForeignColumnName string `db:"foreign_column_name"`
 			ForeignTableName  string `db:"foreign_table_name"`
+			ForeignSchemaName string `db:"foreign_table_schema"`
 		}])
 
 		for _, v := range schemaConstraints {
-
-			tableDetails := tables[v.Tablename]
+			tableDetails, included := tables[v.Tablename]
+			if !included {
+				continue
+			}
 
 			// pk
-			if v.ConstraintType == "PRIMARY KEY" {
+			if v.ConstraintType == "PRIMARY KEY" && !filter.SkipPrimaryKeys {
 				tableDetails.PrimaryKey = v.SourceColumnName
 				tables[v.Tablename] = tableDetails
 			}
 
 			// foreign key
-			if v.ConstraintType == "FOREIGN KEY" {
+			if v.ConstraintType == "FOREIGN KEY" && !filter.SkipForeignKeys {
 				tableDetails.ForeignKeys = append(tableDetails.ForeignKeys, ForeignKey{
 					ForeignTableName:  v.ForeignTableName,
 					ForeignColumnName: v.ForeignColumnName,
+					ForeignSchemaName: v.ForeignSchemaName,
 					SourceColumn:      v.SourceColumnName,
 				})
 				tables[v.Tablename] = tableDetails
@@ -555,9 +649,9 @@ func getSchemaDetails(dbConn *pgx.Conn, ctx context.Context, spinner *spinner.Sp
 	return tables, nil
 }
 
-func generateCreateTableQuery(table string, columns []Column) string {
+func generateCreateTableQuery(schema, table string, columns []Column) string {
 	var stringBuilder strings.Builder
-	stringBuilder.WriteString(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s(\n", table))
+	stringBuilder.WriteString(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s(\n", quoteIdentifier(schema, table)))
 
 	numOfCols := len(columns)
 
@@ -567,7 +661,7 @@ func generateCreateTableQuery(table string, columns []Column) string {
 		if !col.Nullable {
 			nullConstraintString = "NOT NULL"
 		}
-		stringBuilder.WriteString(fmt.Sprintf("%v %v %v", col.ColumnName, col.ColumnType, nullConstraintString))
+		stringBuilder.WriteString(fmt.Sprintf("%v %v %v", quoteIdentifier(col.ColumnName), col.ColumnType, nullConstraintString))
 
 		// add comma if not the last column
 		if i < numOfCols-1 {
@@ -579,3 +673,63 @@ func generateCreateTableQuery(table string, columns []Column) string {
 
 	return stringBuilder.String()
 }
+
+// what a column that takes its default from a sequence is written as, so the
+// sequence is created along with the table
+var serialTypes = map[string]string{"smallint": "smallserial", "integer": "serial", "bigint": "bigserial"}
+
+// serialType is the serial type of a column that takes its default from a
+// sequence, if it has one
+func serialType(col Column) (string, bool) {
+	serial, ok := serialTypes[col.ColumnType]
+	return serial, ok && col.ColumnDefault != nil && strings.HasPrefix(*col.ColumnDefault, "nextval(")
+}
+
+// generateCreateTableWithDefaultsQuery is generateCreateTableQuery with the
+// column defaults, for when the table has to be created the way the source has it
+func generateCreateTableWithDefaultsQuery(schema, table string, columns []Column) string {
+	definitions := make([]string, 0, len(columns))
+	for _, col := range columns {
+		definition := fmt.Sprintf("%v %v", quoteIdentifier(col.ColumnName), col.ColumnType)
+		// the sequence itself is not part of the table details
+		if serial, ok := serialType(col); ok {
+			definition = fmt.Sprintf("%v %v", quoteIdentifier(col.ColumnName), serial)
+		} else if col.ColumnDefault != nil {
+			definition += " DEFAULT " + *col.ColumnDefault
+		}
+		if !col.Nullable {
+			definition += " NOT NULL"
+		}
+		definitions = append(definitions, definition)
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s(\n%s\n);", quoteIdentifier(schema, table), strings.Join(definitions, ", "))
+}
+
+func generateDropTableQuery(schema, table string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE;", quoteIdentifier(schema, table))
+}
+
+func generatePrimaryKeyQuery(schema, table, column string) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s);", quoteIdentifier(schema, table), quoteIdentifier(column))
+}
+
+func generateForeignKeyQuery(schema, table, foreignSchema string, fk ForeignKey) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD FOREIGN KEY (%s) REFERENCES %s(%s);",
+		quoteIdentifier(schema, table),
+		quoteIdentifier(fk.SourceColumn),
+		quoteIdentifier(foreignSchema, fk.ForeignTableName),
+		quoteIdentifier(fk.ForeignColumnName),
+	)
+}
+
+// quoteIdentifier is the only way names should end up in generated sql.
+// quoteIdentifier("public", "user") -> "public"."user"
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func quoteIdentifier(parts ...string) string {
+	return pgx.Identifier(parts).Sanitize()
+}
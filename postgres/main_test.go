@@ -0,0 +1,42 @@
+package postgres
+
+import "testing"
+
+func TestGenerateCreateTableWithDefaultsQuery(t *testing.T) {
+	nextval := "nextval('users_id_seq'::regclass)"
+	now := "now()"
+	status := "'active'::text"
+
+	tests := []struct {
+		name    string
+		columns []Column
+		want    string
+	}{
+		{
+			name:    "no defaults",
+			columns: []Column{{ColumnName: "id", ColumnType: "integer"}, {ColumnName: "note", ColumnType: "text", Nullable: true}},
+			want:    "CREATE TABLE IF NOT EXISTS \"public\".\"users\"(\n\"id\" integer NOT NULL, \"note\" text\n);",
+		},
+		{
+			name: "defaults",
+			columns: []Column{
+				{ColumnName: "created_at", ColumnType: "timestamp with time zone", ColumnDefault: &now},
+				{ColumnName: "status", ColumnType: "text", Nullable: true, ColumnDefault: &status},
+			},
+			want: "CREATE TABLE IF NOT EXISTS \"public\".\"users\"(\n\"created_at\" timestamp with time zone DEFAULT now() NOT NULL, \"status\" text DEFAULT 'active'::text\n);",
+		},
+		{
+			name:    "sequence default becomes serial",
+			columns: []Column{{ColumnName: "id", ColumnType: "bigint", ColumnDefault: &nextval}},
+			want:    "CREATE TABLE IF NOT EXISTS \"public\".\"users\"(\n\"id\" bigserial NOT NULL\n);",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := generateCreateTableWithDefaultsQuery("public", "users", tt.columns); got != tt.want {
+				t.Errorf("generateCreateTableWithDefaultsQuery() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package postgres
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// masking strategies
+const (
+	MaskNull       = "null"
+	MaskFixed      = "fixed"
+	MaskHash       = "hash"
+	MaskName       = "name"
+	MaskEmail      = "email"
+	MaskPhone      = "phone"
+	MaskKeepFormat = "keep_format"
+)
+
+type MaskRule struct {
+	Strategy string `json:"strategy"`
+	Value    any    `json:"value,omitempty"` // only used by fixed
+}
+
+// Masker rewrites column values while rows are copied. rules are keyed by
+// table (or source_schema.table) and then column. every strategy is derived
+// from a keyed hash of the original value alone, so the same input always
+// gives the same output no matter which table or column it is in
+type Masker struct {
+	rules  map[string]map[string]MaskRule
+	secret []byte
+}
+
+// LoadMaskConfig reads a masking config file. an empty path means no masking
+func LoadMaskConfig(path, secret string) (*Masker, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading mask config: %w", err)
+	}
+
+	var rules map[string]map[string]MaskRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error while parsing mask config: %w", err)
+	}
+
+	for table, columns := range rules {
+		for column, rule := range columns {
+			switch rule.Strategy {
+			case MaskNull, MaskFixed:
+			case MaskHash, MaskName, MaskEmail, MaskPhone, MaskKeepFormat:
+				// without a key anyone can hash a list of guesses and match
+				// them against the masked values
+				if secret == "" {
+					return nil, fmt.Errorf("the '%v' masking strategy needs a --mask-secret (%v.%v)", rule.Strategy, table, column)
+				}
+			default:
+				return nil, fmt.Errorf("'%v' is not a valid masking strategy (%v.%v)", rule.Strategy, table, column)
+			}
+		}
+	}
+
+	return &Masker{rules: rules, secret: []byte(secret)}, nil
+}
+
+// fingerprint identifies the rules together with the secret, without giving
+// the secret away. no masking gives an empty fingerprint
+func (m *Masker) fingerprint() string {
+	if m == nil {
+		return ""
+	}
+	rules, _ := json.Marshal(m.rules) // map keys come out sorted
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write(rules)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// the types keep_format knows the text form of. anything else, like numeric,
+// uuid or timestamps, comes out of pgx as a go value that does not print the
+// way postgres reads it back
+var keepFormatTypes = []string{"text", "character varying", "character", "smallint", "integer", "bigint"}
+
+// checkTables makes sure every table and column the rules name is among the
+// tables that are copied. a typo would otherwise copy the real values
+func (m *Masker) checkTables(structures map[SchemaMapping]map[string]Table, refs []tableRef) error {
+	if m == nil {
+		return nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(m.rules)) {
+		columns := make(map[string]string)
+		found := false
+		for _, ref := range refs {
+			if name == ref.Table || name == ref.Schema.Source+"."+ref.Table {
+				found = true
+				for _, col := range structures[ref.Schema][ref.Table].Columns {
+					columns[col.ColumnName] = col.ColumnType
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("the mask config has rules for table '%v', which is not copied", name)
+		}
+
+		for _, column := range slices.Sorted(maps.Keys(m.rules[name])) {
+			columnType, exists := columns[column]
+			if !exists {
+				return fmt.Errorf("the mask config has a rule for column '%v' of table '%v', which has no such column", column, name)
+			}
+			if typeName, _ := splitColumnType(columnType); m.rules[name][column].Strategy == MaskKeepFormat && !slices.Contains(keepFormatTypes, typeName) {
+				return fmt.Errorf("the 'keep_format' masking strategy only works on text and integer columns, not on %v (%v.%v)", columnType, name, column)
+			}
+		}
+	}
+	return nil
+}
+
+// rulesFor returns the rules of a table, schema qualified rules win
+func (m *Masker) rulesFor(ref tableRef) map[string]MaskRule {
+	if m == nil {
+		return nil
+	}
+	if rules, exists := m.rules[ref.Schema.Source+"."+ref.Table]; exists {
+		return rules
+	}
+	return m.rules[ref.Table]
+}
+
+// wrap puts the masking rules of a table in between the source rows and
+// CopyFrom. tables without rules are passed through untouched
+func (m *Masker) wrap(ref tableRef, columns []string, rows pgx.CopyFromSource) pgx.CopyFromSource {
+	rules := m.rulesFor(ref)
+	if len(rules) == 0 {
+		return rows
+	}
+
+	byIndex := make(map[int]MaskRule)
+	for i, column := range columns {
+		if rule, exists := rules[column]; exists {
+			byIndex[i] = rule
+		}
+	}
+	if len(byIndex) == 0 {
+		return rows
+	}
+
+	return &maskedRows{CopyFromSource: rows, masker: m, rules: byIndex}
+}
+
+type maskedRows struct {
+	pgx.CopyFromSource
+	masker *Masker
+	rules  map[int]MaskRule
+}
+
+func (r *maskedRows) Values() ([]any, error) {
+	values, err := r.CopyFromSource.Values()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range r.rules {
+		values[i], err = r.masker.mask(rule, values[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func (m *Masker) mask(rule MaskRule, value any) (any, error) {
+	switch rule.Strategy {
+	case MaskNull:
+		return nil, nil
+	case MaskFixed:
+		return rule.Value, nil
+	}
+
+	// nulls stay null, there is nothing to hide
+	if value == nil {
+		return nil, nil
+	}
+
+	original := fmt.Sprint(value)
+	if s, ok := value.(string); ok {
+		original = s
+	}
+	digest := m.digest(original)
+
+	switch rule.Strategy {
+	case MaskHash:
+		return hex.EncodeToString(digest[:16]), nil
+	case MaskName:
+		return fakeName(digest), nil
+	case MaskEmail:
+		return fakeEmail(digest), nil
+	case MaskPhone:
+		return fakePhone(digest), nil
+	case MaskKeepFormat:
+		masked := keepFormat(original, m.digestStream(original, len(original)))
+		// numbers have to go back in as numbers that fit their column
+		switch value.(type) {
+		case int16:
+			return int16(keepFormatInt(masked, math.MaxInt16)), nil
+		case int32:
+			return int32(keepFormatInt(masked, math.MaxInt32)), nil
+		case int64:
+			return keepFormatInt(masked, math.MaxInt64), nil
+		case int:
+			return int(keepFormatInt(masked, math.MaxInt)), nil
+		case string:
+			return masked, nil
+		}
+		return nil, fmt.Errorf("the 'keep_format' masking strategy only works on text and integer columns, not on %T values", value)
+	}
+
+	return nil, fmt.Errorf("'%v' is not a valid masking strategy", rule.Strategy)
+}
+
+func (m *Masker) digest(value string) []byte {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// digestStream stretches the digest of a value to at least n bytes
+func (m *Masker) digestStream(value string, n int) []byte {
+	var stream []byte
+	for counter := uint32(0); len(stream) < n; counter++ {
+		mac := hmac.New(sha256.New, m.secret)
+		mac.Write([]byte(value))
+		binary.Write(mac, binary.BigEndian, counter)
+		stream = mac.Sum(stream)
+	}
+	return stream
+}
+
+var fakeFirstNames = []string{
+	"alex", "bailey", "casey", "dana", "elliot", "frankie", "gray", "harper",
+	"indigo", "jamie", "kai", "logan", "morgan", "noel", "oakley", "parker",
+	"quinn", "riley", "sage", "taylor", "umi", "val", "wren", "yael",
+}
+
+var fakeLastNames = []string{
+	"adams", "brooks", "carter", "diaz", "evans", "fischer", "garcia", "hughes",
+	"ito", "jensen", "khan", "larsen", "meyer", "novak", "okafor", "patel",
+	"quintero", "rossi", "silva", "tanaka", "ueda", "vance", "weber", "young",
+}
+
+func fakeName(digest []byte) string {
+	first := fakeFirstNames[int(digest[0])%len(fakeFirstNames)]
+	last := fakeLastNames[int(digest[1])%len(fakeLastNames)]
+	return capitalize(first) + " " + capitalize(last)
+}
+
+func fakeEmail(digest []byte) string {
+	first := fakeFirstNames[int(digest[0])%len(fakeFirstNames)]
+	last := fakeLastNames[int(digest[1])%len(fakeLastNames)]
+	// the suffix keeps different originals from colliding on the same address
+	return fmt.Sprintf("%s.%s.%s@example.com", first, last, hex.EncodeToString(digest[2:6]))
+}
+
+func fakePhone(digest []byte) string {
+	// 555-0100 through 555-0199 are reserved for fiction in every area code
+	return fmt.Sprintf("+1-%03d-555-01%02d", 200+int(binary.BigEndian.Uint16(digest[0:2]))%800, int(digest[2])%100)
+}
+
+// keepFormat swaps every digit for a digit and every letter for a letter of
+// the same case, anything else (dashes, dots, @, spaces) is left in place
+func keepFormat(value string, stream []byte) string {
+	var masked strings.Builder
+	i := 0
+	for _, r := range value {
+		b := stream[i%len(stream)]
+		i++
+
+		switch {
+		case unicode.IsDigit(r):
+			masked.WriteByte('0' + b%10)
+		case unicode.IsUpper(r):
+			masked.WriteByte('A' + b%26)
+		case unicode.IsLower(r):
+			masked.WriteByte('a' + b%26)
+		default:
+			masked.WriteRune(r)
+		}
+	}
+	return masked.String()
+}
+
+// keepFormatInt reads the masked digits of a number back, reduced modulo the
+// range of its type so 30000 can not turn into a 99999 that overflows a
+// smallint
+func keepFormatInt(masked string, max int64) int64 {
+	digits, negative := strings.CutPrefix(masked, "-")
+
+	n, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return 0
+	}
+	n.Mod(n, new(big.Int).Add(big.NewInt(max), big.NewInt(1)))
+
+	if negative {
+		return -n.Int64()
+	}
+	return n.Int64()
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
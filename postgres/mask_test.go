@@ -0,0 +1,298 @@
+package postgres
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestMask(t *testing.T) {
+	masker := &Masker{secret: []byte("secret")}
+
+	tests := []struct {
+		name     string
+		strategy string
+		value    any
+		check    func(t *testing.T, masked any)
+	}{
+		{
+			name:     "null",
+			strategy: MaskNull,
+			value:    "alice",
+			check: func(t *testing.T, masked any) {
+				if masked != nil {
+					t.Errorf("got %v, want nil", masked)
+				}
+			},
+		},
+		{
+			name:     "hash",
+			strategy: MaskHash,
+			value:    "alice",
+			check: func(t *testing.T, masked any) {
+				if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(masked.(string)) {
+					t.Errorf("got %v, want 32 hex characters", masked)
+				}
+			},
+		},
+		{
+			name:     "name",
+			strategy: MaskName,
+			value:    "Alice Smith",
+			check: func(t *testing.T, masked any) {
+				if !regexp.MustCompile(`^[A-Z][a-z]+ [A-Z][a-z]+$`).MatchString(masked.(string)) {
+					t.Errorf("got %v, want a first and last name", masked)
+				}
+			},
+		},
+		{
+			name:     "email",
+			strategy: MaskEmail,
+			value:    "alice@company.com",
+			check: func(t *testing.T, masked any) {
+				if !regexp.MustCompile(`^[a-z]+\.[a-z]+\.[0-9a-f]{8}@example\.com$`).MatchString(masked.(string)) {
+					t.Errorf("got %v, want an address at example.com", masked)
+				}
+			},
+		},
+		{
+			name:     "phone",
+			strategy: MaskPhone,
+			value:    "+31 6 12345678",
+			check: func(t *testing.T, masked any) {
+				if !regexp.MustCompile(`^\+1-\d{3}-555-01\d{2}$`).MatchString(masked.(string)) {
+					t.Errorf("got %v, want a 555-01xx number", masked)
+				}
+			},
+		},
+		{
+			name:     "keep format of text",
+			strategy: MaskKeepFormat,
+			value:    "AB-1234 cd",
+			check: func(t *testing.T, masked any) {
+				s := masked.(string)
+				if len(s) != len("AB-1234 cd") {
+					t.Fatalf("got %v, want the same length", s)
+				}
+				for i, r := range s {
+					original := rune("AB-1234 cd"[i])
+					switch {
+					case unicode.IsDigit(original) && !unicode.IsDigit(r),
+						unicode.IsUpper(original) && !unicode.IsUpper(r),
+						unicode.IsLower(original) && !unicode.IsLower(r),
+						!unicode.IsLetter(original) && !unicode.IsDigit(original) && r != original:
+						t.Errorf("got %v, character %v does not keep the format", s, i)
+					}
+				}
+			},
+		},
+		{
+			name:     "keep format of a smallint",
+			strategy: MaskKeepFormat,
+			value:    int16(30000),
+			check: func(t *testing.T, masked any) {
+				if _, ok := masked.(int16); !ok {
+					t.Errorf("got %T, want int16", masked)
+				}
+			},
+		},
+		{
+			name:     "keep format of a negative integer",
+			strategy: MaskKeepFormat,
+			value:    int32(math.MinInt32),
+			check: func(t *testing.T, masked any) {
+				n, ok := masked.(int32)
+				if !ok || n > 0 {
+					t.Errorf("got %v (%T), want a negative int32", masked, masked)
+				}
+			},
+		},
+		{
+			name:     "keep format of a bigint",
+			strategy: MaskKeepFormat,
+			value:    int64(math.MaxInt64),
+			check: func(t *testing.T, masked any) {
+				if _, ok := masked.(int64); !ok {
+					t.Errorf("got %T, want int64", masked)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := MaskRule{Strategy: tt.strategy}
+			masked, err := masker.mask(rule, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, masked)
+
+			again, _ := masker.mask(rule, tt.value)
+			if again != masked {
+				t.Errorf("masking twice gave %v and %v", masked, again)
+			}
+		})
+	}
+}
+
+func TestMaskKeepFormatOtherTypes(t *testing.T) {
+	masker := &Masker{secret: []byte("secret")}
+	for _, value := range []any{3.14, true, [16]byte{1, 2, 3}} {
+		if masked, err := masker.mask(MaskRule{Strategy: MaskKeepFormat}, value); err == nil {
+			t.Errorf("keep_format masked %T %v into %v, want an error", value, value, masked)
+		}
+	}
+}
+
+func TestMaskKeepsNulls(t *testing.T) {
+	masker := &Masker{secret: []byte("secret")}
+	for _, strategy := range []string{MaskHash, MaskName, MaskEmail, MaskPhone, MaskKeepFormat} {
+		if masked, err := masker.mask(MaskRule{Strategy: strategy}, nil); err != nil || masked != nil {
+			t.Errorf("%v masked null into %v (%v)", strategy, masked, err)
+		}
+	}
+}
+
+func TestMaskDependsOnSecret(t *testing.T) {
+	a, _ := (&Masker{secret: []byte("one")}).mask(MaskRule{Strategy: MaskHash}, "alice")
+	b, _ := (&Masker{secret: []byte("two")}).mask(MaskRule{Strategy: MaskHash}, "alice")
+	if a == b {
+		t.Errorf("different secrets gave the same hash %v", a)
+	}
+}
+
+func TestKeepFormatInt(t *testing.T) {
+	tests := []struct {
+		masked string
+		max    int64
+		want   int64
+	}{
+		{"123", math.MaxInt16, 123},
+		{"99999", math.MaxInt16, 99999 % (math.MaxInt16 + 1)},
+		{"-99999", math.MaxInt16, -(99999 % (math.MaxInt16 + 1))},
+		{"9999999999", math.MaxInt32, 9999999999 % (math.MaxInt32 + 1)},
+		{"99999999999999999999", math.MaxInt64, 99999999999999999999 % (math.MaxInt64 + 1)},
+		{"", math.MaxInt16, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.masked, func(t *testing.T) {
+			if got := keepFormatInt(tt.masked, tt.max); got != tt.want {
+				t.Errorf("keepFormatInt(%q, %v) = %v, want %v", tt.masked, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMaskConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		secret  string
+		wantErr string
+	}{
+		{name: "fixed and null without a secret", config: `{"users": {"email": {"strategy": "null"}, "country": {"strategy": "fixed", "value": "NL"}}}`},
+		{name: "hash with a secret", config: `{"users": {"email": {"strategy": "hash"}}}`, secret: "s"},
+		{name: "hash without a secret", config: `{"users": {"email": {"strategy": "hash"}}}`, wantErr: "needs a --mask-secret"},
+		{name: "keep format without a secret", config: `{"users": {"zip": {"strategy": "keep_format"}}}`, wantErr: "needs a --mask-secret"},
+		{name: "unknown strategy", config: `{"users": {"email": {"strategy": "shuffle"}}}`, secret: "s", wantErr: "not a valid masking strategy"},
+		{name: "not json", config: `{`, wantErr: "error while parsing mask config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mask.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			masker, err := LoadMaskConfig(path, tt.secret)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || masker == nil {
+				t.Fatalf("got %v, %v", masker, err)
+			}
+		})
+	}
+}
+
+func TestMaskerFingerprint(t *testing.T) {
+	rules := map[string]map[string]MaskRule{"users": {"email": {Strategy: MaskEmail}}}
+	other := map[string]map[string]MaskRule{"users": {"email": {Strategy: MaskHash}}}
+
+	base := (&Masker{rules: rules, secret: []byte("one")}).fingerprint()
+
+	tests := []struct {
+		name   string
+		masker *Masker
+		same   bool
+	}{
+		{name: "same rules and secret", masker: &Masker{rules: rules, secret: []byte("one")}, same: true},
+		{name: "other secret", masker: &Masker{rules: rules, secret: []byte("two")}},
+		{name: "other rules", masker: &Masker{rules: other, secret: []byte("one")}},
+		{name: "no masking", masker: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.masker.fingerprint(); (got == base) != tt.same {
+				t.Errorf("fingerprint %v, base %v, want same = %v", got, base, tt.same)
+			}
+		})
+	}
+}
+
+func TestMaskerCheckTables(t *testing.T) {
+	public := SchemaMapping{Source: "public", Target: "public"}
+	structures := map[SchemaMapping]map[string]Table{public: {
+		"users": {Columns: []Column{
+			{ColumnName: "id", ColumnType: "integer"},
+			{ColumnName: "email", ColumnType: "text"},
+			{ColumnName: "zip", ColumnType: "character varying(10)"},
+			{ColumnName: "balance", ColumnType: "numeric(12,2)"},
+			{ColumnName: "token", ColumnType: "uuid"},
+		}},
+	}}
+	refs := []tableRef{{Schema: public, Table: "users"}}
+
+	tests := []struct {
+		name    string
+		rules   map[string]map[string]MaskRule
+		wantErr string
+	}{
+		{name: "by table", rules: map[string]map[string]MaskRule{"users": {"email": {Strategy: MaskEmail}}}},
+		{name: "by schema and table", rules: map[string]map[string]MaskRule{"public.users": {"email": {Strategy: MaskEmail}}}},
+		{name: "unknown table", rules: map[string]map[string]MaskRule{"user": {"email": {Strategy: MaskEmail}}}, wantErr: "rules for table 'user'"},
+		{name: "other schema", rules: map[string]map[string]MaskRule{"app.users": {"email": {Strategy: MaskEmail}}}, wantErr: "rules for table 'app.users'"},
+		{name: "unknown column", rules: map[string]map[string]MaskRule{"users": {"e_mail": {Strategy: MaskEmail}}}, wantErr: "column 'e_mail' of table 'users'"},
+		{name: "keep format of text", rules: map[string]map[string]MaskRule{"users": {"email": {Strategy: MaskKeepFormat}}}},
+		{name: "keep format of an integer", rules: map[string]map[string]MaskRule{"users": {"id": {Strategy: MaskKeepFormat}}}},
+		{name: "keep format of a varchar", rules: map[string]map[string]MaskRule{"users": {"zip": {Strategy: MaskKeepFormat}}}},
+		{name: "keep format of a numeric", rules: map[string]map[string]MaskRule{"users": {"balance": {Strategy: MaskKeepFormat}}}, wantErr: "not on numeric(12,2)"},
+		{name: "keep format of a uuid", rules: map[string]map[string]MaskRule{"users": {"token": {Strategy: MaskKeepFormat}}}, wantErr: "not on uuid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Masker{rules: tt.rules, secret: []byte("s")}).checkTables(structures, refs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
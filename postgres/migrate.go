@@ -0,0 +1,474 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+const migrationsTable = "gograte_migrations"
+
+// 001_create_users.up.sql / 001_create_users.down.sql
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type migration struct {
+	Version  int64
+	Name     string
+	Label    string // file name without the .up.sql/.down.sql
+	UpFile   string
+	DownFile string
+	Checksum string // of the up file
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+func MigrateMethod(targetDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, action, dir string, schemas []SchemaMapping, filter Filter) error {
+	// runs hand written, numbered migrations against the target and keeps
+	// track of them in the gograte_migrations table of the first target schema
+
+	historySchema := schemas[0].Target
+
+	// status only reads, a target without the table has no history yet
+	if action != "status" {
+		if err := ensureMigrationsTable(targetDbConn, ctx, historySchema); err != nil {
+			return err
+		}
+	}
+
+	applied, err := loadAppliedMigrations(targetDbConn, ctx, historySchema)
+	if err != nil {
+		return err
+	}
+
+	// the migrations directory usually doesnt exist yet when baselining
+	if action == "baseline" {
+		return migrateBaseline(targetDbConn, ctx, spinner, dir, schemas, filter, applied)
+	}
+
+	migrations, err := loadMigrations(dir)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "up":
+		return migrateUp(targetDbConn, ctx, spinner, migrations, applied, historySchema)
+	case "down":
+		return migrateDown(targetDbConn, ctx, spinner, migrations, applied, historySchema)
+	case "status":
+		return migrateStatus(migrations, applied)
+	case "":
+		return fmt.Errorf("no migrate action provided (up, down, status, baseline)")
+	default:
+		return fmt.Errorf("'%v' is not a valid migrate action (up, down, status, baseline)", action)
+	}
+}
+
+func migrateUp(targetDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, migrations []migration, applied map[int64]appliedMigration, historySchema string) error {
+	if err := checkAppliedMigrations(migrations, applied); err != nil {
+		return err
+	}
+
+	var latestApplied int64 = -1
+	for version := range applied {
+		latestApplied = max(latestApplied, version)
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; done {
+			continue
+		}
+		// applying an older migration on top of newer ones usually means two
+		// branches picked numbers independently, make someone look at it
+		if m.Version < latestApplied {
+			return fmt.Errorf("migration %v is older than the latest applied migration %v, renumber it", m.Label, latestApplied)
+		}
+		pending = append(pending, m)
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("no pending migrations")
+		return nil
+	}
+
+	startTime := time.Now()
+	for _, m := range pending {
+		spinner.Start()
+		spinner.Suffix = " applying migration " + m.Label
+
+		err := runMigration(targetDbConn, ctx, m.UpFile, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (version, name, checksum) VALUES ($1, $2, $3);", quoteIdentifier(historySchema, migrationsTable)), m.Version, m.Name, m.Checksum)
+			return err
+		})
+		spinner.Stop()
+		if err != nil {
+			fmt.Println("error while applying migration " + m.Label)
+			return err
+		}
+
+		fmt.Println("applied " + m.Label)
+	}
+
+	fmt.Printf("\nApplied %v migrations in %v seconds\n", len(pending), time.Since(startTime))
+	return nil
+}
+
+func migrateDown(targetDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, migrations []migration, applied map[int64]appliedMigration, historySchema string) error {
+	if len(applied) == 0 {
+		fmt.Println("no applied migrations to revert")
+		return nil
+	}
+
+	// only ever the latest one, reverting is something to do step by step
+	var latest int64 = -1
+	for version := range applied {
+		latest = max(latest, version)
+	}
+
+	var target *migration
+	for i := range migrations {
+		if migrations[i].Version == latest {
+			target = &migrations[i]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("migration %v (%v) was applied but its files are missing", latest, applied[latest].Name)
+	}
+	if target.DownFile == "" {
+		return fmt.Errorf("migration %v has no .down.sql file", target.Label)
+	}
+	if target.Checksum != applied[latest].Checksum {
+		return fmt.Errorf("migration %v was edited after it was applied", target.Label)
+	}
+
+	spinner.Start()
+	spinner.Suffix = " reverting migration " + target.Label
+
+	err := runMigration(targetDbConn, ctx, target.DownFile, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE version = $1;", quoteIdentifier(historySchema, migrationsTable)), target.Version)
+		return err
+	})
+	spinner.Stop()
+	if err != nil {
+		fmt.Println("error while reverting migration " + target.Label)
+		return err
+	}
+
+	fmt.Println("reverted " + target.Label)
+	return nil
+}
+
+// migrateBaseline writes the current target schema out as the first migration
+// and marks it applied, so a database that predates migrations has something
+// to build on
+func migrateBaseline(targetDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, dir string, schemas []SchemaMapping, filter Filter, applied map[int64]appliedMigration) error {
+	if len(applied) > 0 {
+		return fmt.Errorf("the target already has %v applied migrations, baseline is only for databases without a history", len(applied))
+	}
+
+	existing, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	for _, file := range existing {
+		if migrationFilePattern.MatchString(filepath.Base(file)) {
+			return fmt.Errorf("%v already holds migrations, baseline is only for starting out", dir)
+		}
+	}
+
+	spinner.Start()
+	spinner.Suffix = " getting table details"
+
+	structures := make(map[string]map[string]Table)
+	for _, schema := range schemas {
+		tables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, true, filter)
+		if err != nil {
+			spinner.Stop()
+			fmt.Println("error while getting target table schema")
+			return err
+		}
+		// the history table belongs to gograte, not to the schema
+		if schema.Target == schemas[0].Target {
+			delete(tables, migrationsTable)
+		}
+		structures[schema.Target] = tables
+	}
+
+	spinner.Stop()
+
+	up, down := generateBaseline(schemas, structures)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Println("error while creating migrations directory")
+		return err
+	}
+
+	upFile := filepath.Join(dir, "0001_baseline.up.sql")
+	downFile := filepath.Join(dir, "0001_baseline.down.sql")
+	if err := os.WriteFile(upFile, []byte(up), 0644); err != nil {
+		fmt.Println("error while writing baseline migration")
+		return err
+	}
+	if err := os.WriteFile(downFile, []byte(down), 0644); err != nil {
+		fmt.Println("error while writing baseline migration")
+		return err
+	}
+
+	// the schema is already there, so only record it
+	sum := sha256.Sum256([]byte(up))
+	_, err = targetDbConn.Exec(ctx, fmt.Sprintf("INSERT INTO %s (version, name, checksum) VALUES ($1, $2, $3);", quoteIdentifier(schemas[0].Target, migrationsTable)), 1, "baseline", hex.EncodeToString(sum[:]))
+	if err != nil {
+		fmt.Println("error while updating migration history")
+		return err
+	}
+
+	numOfTables := 0
+	for _, tables := range structures {
+		numOfTables += len(tables)
+	}
+	fmt.Printf("baseline of %v tables written to %v and marked as applied\n", numOfTables, upFile)
+	return nil
+}
+
+// generateBaseline renders the tables as the up and down sql of a migration,
+// sorted so the same schema always gives the same files
+func generateBaseline(schemas []SchemaMapping, structures map[string]map[string]Table) (string, string) {
+	var up, down strings.Builder
+	up.WriteString("-- baseline generated by gograte from the existing schema\n")
+	down.WriteString("-- reverts the baseline, ALL DATA WILL BE LOST\n")
+
+	for _, schema := range schemas {
+		for _, table := range sortedTableNames(structures[schema.Target]) {
+			up.WriteString("\n" + generateCreateTableWithDefaultsQuery(schema.Target, table, structures[schema.Target][table].Columns) + "\n")
+		}
+	}
+
+	// keys after all tables so foreign keys never point at a table that
+	// doesnt exist yet
+	var keys []string
+	for _, schema := range schemas {
+		for _, table := range sortedTableNames(structures[schema.Target]) {
+			if pk := structures[schema.Target][table].PrimaryKey; pk != "" {
+				keys = append(keys, generatePrimaryKeyQuery(schema.Target, table, pk))
+			}
+		}
+	}
+	for _, schema := range schemas {
+		for _, table := range sortedTableNames(structures[schema.Target]) {
+			for _, fk := range structures[schema.Target][table].ForeignKeys {
+				keys = append(keys, generateForeignKeyQuery(schema.Target, table, fk.ForeignSchemaName, fk))
+			}
+		}
+	}
+	if len(keys) > 0 {
+		up.WriteString("\n" + strings.Join(keys, "\n") + "\n")
+	}
+
+	for i := len(schemas) - 1; i >= 0; i-- {
+		tables := sortedTableNames(structures[schemas[i].Target])
+		for j := len(tables) - 1; j >= 0; j-- {
+			down.WriteString(generateDropTableQuery(schemas[i].Target, tables[j]) + "\n")
+		}
+	}
+
+	return up.String(), down.String()
+}
+
+func migrateStatus(migrations []migration, applied map[int64]appliedMigration) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+
+	seen := make(map[int64]bool)
+	for _, m := range migrations {
+		seen[m.Version] = true
+
+		status := "pending"
+		if a, done := applied[m.Version]; done {
+			status = "applied " + a.AppliedAt.Local().Format(time.DateTime)
+			if a.Checksum != m.Checksum {
+				status += " (EDITED since)"
+			}
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", m.Version, m.Name, status)
+	}
+
+	var missing []int64
+	for version := range applied {
+		if !seen[version] {
+			missing = append(missing, version)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	for _, version := range missing {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", version, applied[version].Name, "applied, FILE MISSING")
+	}
+
+	return w.Flush()
+}
+
+// runMigration runs a migration file and its bookkeeping in one transaction,
+// the same way ReplaceMethod wraps its work
+func runMigration(targetDbConn *pgx.Conn, ctx context.Context, file string, record func(tx pgx.Tx) error) error {
+	sql, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	tx, err := targetDbConn.Begin(ctx)
+	if err != nil {
+		fmt.Println("error while beginning transaction")
+		return err
+	}
+	defer tx.Rollback(ctx) // rollback if we dont commit!!!!!!
+
+	// no arguments means the simple protocol, which runs every statement in the file
+	if _, err := tx.Exec(ctx, string(sql)); err != nil {
+		return fmt.Errorf("error in %v: %w", file, err)
+	}
+
+	if err := record(tx); err != nil {
+		fmt.Println("error while updating migration history")
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// checkAppliedMigrations refuses to go on when history and files disagree
+func checkAppliedMigrations(migrations []migration, applied map[int64]appliedMigration) error {
+	byVersion := make(map[int64]migration)
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	for version, a := range applied {
+		m, exists := byVersion[version]
+		if !exists {
+			return fmt.Errorf("migration %v (%v) was applied but its files are missing", version, a.Name)
+		}
+		if m.Checksum != a.Checksum {
+			return fmt.Errorf("migration %v was edited after it was applied", m.Label)
+		}
+	}
+
+	return nil
+}
+
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading migrations directory: %w", err)
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %v: %w", entry.Name(), err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{Version: version, Name: match[2], Label: match[1] + "_" + match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %v is used by both %v and %v", version, m.Name, match[2])
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if match[3] == "up" {
+			m.UpFile = path
+		} else {
+			m.DownFile = path
+		}
+	}
+
+	var migrations []migration
+	for _, m := range byVersion {
+		if m.UpFile == "" {
+			return nil, fmt.Errorf("migration %v has no .up.sql file", m.Label)
+		}
+
+		data, err := os.ReadFile(m.UpFile)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		m.Checksum = hex.EncodeToString(sum[:])
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func ensureMigrationsTable(targetDbConn *pgx.Conn, ctx context.Context, historySchema string) error {
+	_, err := targetDbConn.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			checksum text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		);
+	`, quoteIdentifier(historySchema, migrationsTable)))
+	if err != nil {
+		fmt.Println("error while creating migration history table")
+		return err
+	}
+	return nil
+}
+
+func loadAppliedMigrations(targetDbConn *pgx.Conn, ctx context.Context, historySchema string) (map[int64]appliedMigration, error) {
+	applied := make(map[int64]appliedMigration)
+
+	var exists bool
+	if err := targetDbConn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL;", quoteIdentifier(historySchema, migrationsTable)).Scan(&exists); err != nil {
+		fmt.Println("error while looking up migration history table")
+		return nil, err
+	}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := targetDbConn.Query(ctx, fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s ORDER BY version;", quoteIdentifier(historySchema, migrationsTable)))
+	if err != nil {
+		fmt.Println("error while querying migration history")
+		return nil, err
+	}
+
+	history, err := pgx.CollectRows(rows, pgx.RowToStructByName[appliedMigration])
+	if err != nil {
+		fmt.Println("error while collecting migration history rows")
+		return nil, err
+	}
+
+	for _, a := range history {
+		applied[a.Version] = a
+	}
+	return applied, nil
+}
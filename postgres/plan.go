@@ -0,0 +1,404 @@
+package postgres
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Safety says how much a change can hurt the data or the database it runs on
+type Safety string
+
+const (
+	// can not lose data and does not need to scan existing rows
+	Safe Safety = "safe"
+	// can fail on existing rows, or locks the table while scanning it
+	Risky Safety = "risky"
+	// throws data away
+	Destructive Safety = "destructive"
+)
+
+// Change is a single step that brings a target table closer to its source
+type Change struct {
+	Schema      string   `json:"schema"`
+	Table       string   `json:"table"`
+	Safety      Safety   `json:"safety"`
+	Description string   `json:"description"`
+	Reason      string   `json:"reason,omitempty"`
+	Statements  []string `json:"statements"`
+
+	// online changes split the heavy work out of the main transaction, into
+	// statements that run on their own before or after it
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// conversions that never lose information, from -> to, by the type without
+// its length or precision
+var wideningConversions = map[string][]string{
+	"smallint":          {"integer", "bigint", "numeric", "real", "double precision"},
+	"integer":           {"bigint", "numeric", "double precision"},
+	"bigint":            {"numeric"},
+	"real":              {"double precision"},
+	"character":         {"character varying", "text"},
+	"character varying": {"text"},
+	"date":              {"timestamp without time zone", "timestamp with time zone"},
+}
+
+// the length or precision of a type as format_type writes it, like the (255)
+// of character varying(255) or the (3) of timestamp(3) with time zone
+var typeModifierPattern = regexp.MustCompile(`\((\d+(?:,\d+)*)\)`)
+
+// splitColumnType cuts a type into its name and its modifiers
+func splitColumnType(columnType string) (string, []int) {
+	var modifiers []int
+	if match := typeModifierPattern.FindStringSubmatch(columnType); match != nil {
+		for _, part := range strings.Split(match[1], ",") {
+			n, _ := strconv.Atoi(part)
+			modifiers = append(modifiers, n)
+		}
+	}
+	return strings.Join(strings.Fields(typeModifierPattern.ReplaceAllString(columnType, "")), " "), modifiers
+}
+
+// isWideningConversion tells whether every value of type from fits in type
+// to, lengths and precisions included
+func isWideningConversion(from, to string) bool {
+	fromName, fromModifiers := splitColumnType(from)
+	toName, toModifiers := splitColumnType(to)
+
+	if fromName != toName && !slices.Contains(wideningConversions[fromName], toName) {
+		return false
+	}
+
+	// no modifier means no limit, like numeric or character varying
+	if len(toModifiers) == 0 {
+		return true
+	}
+	if len(fromModifiers) == 0 {
+		return false
+	}
+
+	// numeric(precision, scale) needs room for as many digits on both sides
+	// of the point
+	if fromName == "numeric" && toName == "numeric" {
+		fromScale, toScale := 0, 0
+		if len(fromModifiers) > 1 {
+			fromScale = fromModifiers[1]
+		}
+		if len(toModifiers) > 1 {
+			toScale = toModifiers[1]
+		}
+		return toScale >= fromScale && toModifiers[0]-toScale >= fromModifiers[0]-fromScale
+	}
+
+	return len(fromModifiers) == 1 && len(toModifiers) == 1 && toModifiers[0] >= fromModifiers[0]
+}
+
+// planSchemaChanges works out the changes that turn the target tables into the
+// source tables, in the order they can run in. constraints that only exist in
+// the target are left alone. online swaps the statements that lock existing
+// tables for a long time for ones that dont
+func planSchemaChanges(sourceTables, targetTables map[string]Table, schema SchemaMapping, schemas []SchemaMapping, online bool) []Change {
+	var tables, columns, primaryKeys, foreignKeys, drops []Change
+
+	for _, table := range sortedTableNames(sourceTables) {
+		sourceTable := sourceTables[table]
+		targetTable, exists := targetTables[table]
+
+		if !exists {
+			tables = append(tables, Change{
+				Schema:      schema.Target,
+				Table:       table,
+				Safety:      Safe,
+				Description: fmt.Sprintf("create table %v.%v", schema.Target, table),
+				Statements:  []string{generateCreateTableQuery(schema.Target, table, sourceTable.Columns)},
+			})
+			// a new table is empty, its keys can not fail or take long
+			pks, fks := planKeyChanges(Table{}, sourceTable, table, schema, schemas, Safe, false)
+			primaryKeys, foreignKeys = append(primaryKeys, pks...), append(foreignKeys, fks...)
+			continue
+		}
+
+		targetColumns := make(map[string]Column)
+		for _, col := range targetTable.Columns {
+			targetColumns[col.ColumnName] = col
+		}
+
+		for _, col := range sourceTable.Columns {
+			targetCol, exists := targetColumns[col.ColumnName]
+			if !exists {
+				columns = append(columns, planAddColumn(schema.Target, table, col))
+				continue
+			}
+
+			if targetCol.ColumnType != col.ColumnType {
+				columns = append(columns, planTypeChange(schema.Target, table, targetCol, col))
+			}
+
+			if targetCol.Nullable && !col.Nullable && online {
+				columns = append(columns, planOnlineSetNotNull(schema.Target, table, col.ColumnName))
+			} else if targetCol.Nullable && !col.Nullable {
+				columns = append(columns, Change{
+					Schema:      schema.Target,
+					Table:       table,
+					Safety:      Risky,
+					Description: fmt.Sprintf("set not null on %v.%v.%v", schema.Target, table, col.ColumnName),
+					Reason:      "scans the whole table and fails when it holds nulls",
+					Statements:  []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", quoteIdentifier(schema.Target, table), quoteIdentifier(col.ColumnName))},
+				})
+			} else if !targetCol.Nullable && col.Nullable {
+				columns = append(columns, Change{
+					Schema:      schema.Target,
+					Table:       table,
+					Safety:      Safe,
+					Description: fmt.Sprintf("drop not null on %v.%v.%v", schema.Target, table, col.ColumnName),
+					Statements:  []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;", quoteIdentifier(schema.Target, table), quoteIdentifier(col.ColumnName))},
+				})
+			}
+		}
+
+		pks, fks := planKeyChanges(targetTable, sourceTable, table, schema, schemas, Risky, online)
+		primaryKeys, foreignKeys = append(primaryKeys, pks...), append(foreignKeys, fks...)
+
+		sourceColumns := columnNames(sourceTable.Columns)
+		for _, col := range targetTable.Columns {
+			if !slices.Contains(sourceColumns, col.ColumnName) {
+				drops = append(drops, Change{
+					Schema:      schema.Target,
+					Table:       table,
+					Safety:      Destructive,
+					Description: fmt.Sprintf("drop column %v.%v.%v", schema.Target, table, col.ColumnName),
+					Reason:      "the data in the column is lost",
+					Statements:  []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", quoteIdentifier(schema.Target, table), quoteIdentifier(col.ColumnName))},
+				})
+			}
+		}
+	}
+
+	for _, table := range sortedTableNames(targetTables) {
+		if _, exists := sourceTables[table]; !exists {
+			drops = append(drops, Change{
+				Schema:      schema.Target,
+				Table:       table,
+				Safety:      Destructive,
+				Description: fmt.Sprintf("drop table %v.%v", schema.Target, table),
+				Reason:      "the table and all its rows are lost",
+				Statements:  []string{generateDropTableQuery(schema.Target, table)},
+			})
+		}
+	}
+
+	// keys go after every table and column exists, primary keys before the
+	// foreign keys that may point at them, drops come last
+	var changes []Change
+	changes = append(changes, tables...)
+	changes = append(changes, columns...)
+	changes = append(changes, primaryKeys...)
+	changes = append(changes, foreignKeys...)
+	changes = append(changes, drops...)
+	return changes
+}
+
+// planOnlineSetNotNull proves the column holds no nulls with a check
+// constraint that is validated without blocking writes, after which SET NOT
+// NULL can skip its own scan
+func planOnlineSetNotNull(schema, table, column string) Change {
+	name := quoteIdentifier(constraintName(table, column, "not_null"))
+	return Change{
+		Schema:      schema,
+		Table:       table,
+		Safety:      Risky,
+		Description: fmt.Sprintf("set not null on %v.%v.%v", schema, table, column),
+		Reason:      "validated after the transaction without blocking writes, fails when the column holds nulls",
+		Statements:  []string{fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s IS NOT NULL) NOT VALID;", quoteIdentifier(schema, table), name, quoteIdentifier(column))},
+		After: []string{
+			fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s;", quoteIdentifier(schema, table), name),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", quoteIdentifier(schema, table), quoteIdentifier(column)),
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", quoteIdentifier(schema, table), name),
+		},
+	}
+}
+
+// constraintName follows the postgres naming (users_email_fkey), cut to the
+// 63 bytes a name can have
+func constraintName(parts ...string) string {
+	name := strings.Join(parts, "_")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// keys sync adds are named explicitly, so a name that is already taken fails
+// the sync instead of postgres quietly picking another one, and the rollback
+// knows exactly what to drop
+func primaryKeyName(table string) string {
+	return constraintName(table, "pkey")
+}
+
+func foreignKeyName(table, column string) string {
+	return constraintName(table, column, "fkey")
+}
+
+func planAddColumn(schema, table string, col Column) Change {
+	definition := fmt.Sprintf("%v %v", quoteIdentifier(col.ColumnName), col.ColumnType)
+	if col.ColumnDefault != nil {
+		definition += " DEFAULT " + *col.ColumnDefault
+	}
+	if !col.Nullable {
+		definition += " NOT NULL"
+	}
+
+	change := Change{
+		Schema:      schema,
+		Table:       table,
+		Safety:      Safe,
+		Description: fmt.Sprintf("add column %v.%v.%v", schema, table, col.ColumnName),
+		Statements:  []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", quoteIdentifier(schema, table), definition)},
+	}
+	if !col.Nullable && col.ColumnDefault == nil {
+		change.Safety = Risky
+		change.Reason = "not null without a default fails when the table has rows"
+	}
+	return change
+}
+
+func planTypeChange(schema, table string, from, to Column) Change {
+	change := Change{
+		Schema:      schema,
+		Table:       table,
+		Safety:      Risky,
+		Description: fmt.Sprintf("change type of %v.%v.%v from %v to %v", schema, table, to.ColumnName, from.ColumnType, to.ColumnType),
+		Reason:      "narrowing or converting the type can fail or lose precision, and rewrites the table",
+		Statements: []string{fmt.Sprintf(
+			"ALTER TABLE %s ALTER COLUMN %s TYPE %v USING %s::%v;",
+			quoteIdentifier(schema, table), quoteIdentifier(to.ColumnName), to.ColumnType, quoteIdentifier(to.ColumnName), to.ColumnType,
+		)},
+	}
+	if isWideningConversion(from.ColumnType, to.ColumnType) {
+		change.Safety = Safe
+		change.Reason = ""
+	}
+	return change
+}
+
+// planKeyChanges adds the primary and foreign keys of the source table the
+// target table is missing. a target that already has another primary key
+// keeps it
+func planKeyChanges(targetTable, sourceTable Table, table string, schema SchemaMapping, schemas []SchemaMapping, safety Safety, online bool) ([]Change, []Change) {
+	var primaryKeys, foreignKeys []Change
+
+	if sourceTable.PrimaryKey != "" && targetTable.PrimaryKey == "" {
+		change := Change{
+			Schema:      schema.Target,
+			Table:       table,
+			Safety:      safety,
+			Description: fmt.Sprintf("add primary key (%v) to %v.%v", sourceTable.PrimaryKey, schema.Target, table),
+			Statements:  []string{fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY (%s);", quoteIdentifier(schema.Target, table), quoteIdentifier(primaryKeyName(table)), quoteIdentifier(sourceTable.PrimaryKey))},
+		}
+		if safety == Risky {
+			change.Reason = "builds a unique index with a full table scan and fails on duplicates"
+		}
+		// a column that is only added in the main transaction can not be
+		// indexed before it
+		if online && slices.Contains(columnNames(targetTable.Columns), sourceTable.PrimaryKey) {
+			// the index is what takes long, build it without blocking writes
+			// and turn it into the primary key afterwards
+			index := primaryKeyName(table)
+			change.Before = []string{fmt.Sprintf("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s (%s);", quoteIdentifier(index), quoteIdentifier(schema.Target, table), quoteIdentifier(sourceTable.PrimaryKey))}
+			change.Statements = []string{fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY USING INDEX %s;", quoteIdentifier(schema.Target, table), quoteIdentifier(index), quoteIdentifier(index))}
+			change.Reason = "the unique index is built concurrently before the transaction and fails on duplicates"
+		}
+		primaryKeys = append(primaryKeys, change)
+	}
+
+	for _, fk := range sourceTable.ForeignKeys {
+		foreignSchema := mappedTargetSchema(fk.ForeignSchemaName, schemas)
+
+		exists := false
+		for _, targetFk := range targetTable.ForeignKeys {
+			if targetFk.SourceColumn == fk.SourceColumn && targetFk.ForeignTableName == fk.ForeignTableName &&
+				targetFk.ForeignColumnName == fk.ForeignColumnName && targetFk.ForeignSchemaName == foreignSchema {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+
+		change := Change{
+			Schema:      schema.Target,
+			Table:       table,
+			Safety:      safety,
+			Description: fmt.Sprintf("add foreign key %v.%v(%v) -> %v.%v(%v)", schema.Target, table, fk.SourceColumn, foreignSchema, fk.ForeignTableName, fk.ForeignColumnName),
+			Statements: []string{fmt.Sprintf(
+				"ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s(%s);",
+				quoteIdentifier(schema.Target, table), quoteIdentifier(foreignKeyName(table, fk.SourceColumn)), quoteIdentifier(fk.SourceColumn), quoteIdentifier(foreignSchema, fk.ForeignTableName), quoteIdentifier(fk.ForeignColumnName),
+			)},
+		}
+		if safety == Risky {
+			change.Reason = "checks every existing row with a full table scan"
+		}
+		if online {
+			// NOT VALID only checks new rows, the existing ones are checked
+			// later without blocking writes
+			name := quoteIdentifier(foreignKeyName(table, fk.SourceColumn))
+			change.Statements = []string{fmt.Sprintf(
+				"ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s(%s) NOT VALID;",
+				quoteIdentifier(schema.Target, table), name, quoteIdentifier(fk.SourceColumn), quoteIdentifier(foreignSchema, fk.ForeignTableName), quoteIdentifier(fk.ForeignColumnName),
+			)}
+			change.After = []string{fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s;", quoteIdentifier(schema.Target, table), name)}
+			change.Reason = "existing rows are validated after the transaction, without blocking writes"
+		}
+		foreignKeys = append(foreignKeys, change)
+	}
+
+	return primaryKeys, foreignKeys
+}
+
+// mappedTargetSchema points a source schema at its target counterpart,
+// schemas that are not part of the run are left as is
+func mappedTargetSchema(sourceSchema string, schemas []SchemaMapping) string {
+	for _, schema := range schemas {
+		if schema.Source == sourceSchema {
+			return schema.Target
+		}
+	}
+	return sourceSchema
+}
+
+func countChanges(changes []Change, safety Safety) int {
+	total := 0
+	for _, c := range changes {
+		if c.Safety == safety {
+			total++
+		}
+	}
+	return total
+}
+
+// changeLabel is how a change is shown in the terminal
+func changeLabel(c Change) string {
+	label := fmt.Sprintf("[%v] %v", strings.ToUpper(string(c.Safety)), c.Description)
+	if c.Reason != "" {
+		label += " (" + c.Reason + ")"
+	}
+	return label
+}
+
+// outsideTransaction lists the statements of a change that do not run in
+// the main transaction, for the plan to point out
+func outsideTransaction(c Change) []string {
+	var lines []string
+	for _, statement := range c.Before {
+		lines = append(lines, "before the transaction: "+statement)
+	}
+	for _, statement := range c.After {
+		lines = append(lines, "after the transaction: "+statement)
+	}
+	return lines
+}
@@ -0,0 +1,189 @@
+package postgres
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIsWideningConversion(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"integer", "bigint", true},
+		{"bigint", "integer", false},
+		{"smallint", "numeric", true},
+		{"integer", "numeric(12,2)", false},
+		{"character varying(50)", "character varying(255)", true},
+		{"character varying(255)", "character varying(50)", false},
+		{"character varying(255)", "character varying", true},
+		{"character varying", "character varying(255)", false},
+		{"character varying(255)", "text", true},
+		{"character(10)", "character varying(20)", true},
+		{"character(10)", "character varying(5)", false},
+		{"numeric(6,2)", "numeric(12,2)", true},
+		{"numeric(12,2)", "numeric(6,2)", false},
+		{"numeric(12,2)", "numeric(12,4)", false},
+		{"numeric(12,2)", "numeric(14,4)", true},
+		{"numeric(12,2)", "numeric", true},
+		{"numeric", "numeric(12,2)", false},
+		{"timestamp(3) without time zone", "timestamp(6) without time zone", true},
+		{"timestamp(6) with time zone", "timestamp(3) with time zone", false},
+		{"date", "timestamp with time zone", true},
+		{"text", "integer", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			if got := isWideningConversion(tt.from, tt.to); got != tt.want {
+				t.Errorf("isWideningConversion(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanTypeChange(t *testing.T) {
+	tests := []struct {
+		name       string
+		from, to   string
+		wantSafety Safety
+		wantSQL    string
+	}{
+		{
+			name:       "widening",
+			from:       "integer",
+			to:         "bigint",
+			wantSafety: Safe,
+			wantSQL:    `ALTER TABLE "public"."users" ALTER COLUMN "id" TYPE bigint USING "id"::bigint;`,
+		},
+		{
+			name:       "shorter varchar",
+			from:       "character varying(255)",
+			to:         "character varying(50)",
+			wantSafety: Risky,
+			wantSQL:    `ALTER TABLE "public"."users" ALTER COLUMN "id" TYPE character varying(50) USING "id"::character varying(50);`,
+		},
+		{
+			name:       "converting",
+			from:       "text",
+			to:         "integer",
+			wantSafety: Risky,
+			wantSQL:    `ALTER TABLE "public"."users" ALTER COLUMN "id" TYPE integer USING "id"::integer;`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := planTypeChange("public", "users", Column{ColumnName: "id", ColumnType: tt.from}, Column{ColumnName: "id", ColumnType: tt.to})
+			if change.Safety != tt.wantSafety {
+				t.Errorf("safety = %v, want %v", change.Safety, tt.wantSafety)
+			}
+			if !slices.Equal(change.Statements, []string{tt.wantSQL}) {
+				t.Errorf("statements = %q, want %q", change.Statements, tt.wantSQL)
+			}
+		})
+	}
+}
+
+func TestPlanSchemaChanges(t *testing.T) {
+	schema := SchemaMapping{Source: "public", Target: "public"}
+	defaultZero := "0"
+
+	tests := []struct {
+		name   string
+		source map[string]Table
+		target map[string]Table
+		online bool
+		want   []Change
+	}{
+		{
+			name:   "nothing to do",
+			source: map[string]Table{"users": {PrimaryKey: "id", Columns: []Column{{ColumnName: "id", ColumnType: "integer"}}}},
+			target: map[string]Table{"users": {PrimaryKey: "id", Columns: []Column{{ColumnName: "id", ColumnType: "integer"}}}},
+		},
+		{
+			name:   "new table with its keys",
+			source: map[string]Table{"users": {PrimaryKey: "id", Columns: []Column{{ColumnName: "id", ColumnType: "integer"}}}},
+			target: map[string]Table{},
+			want: []Change{
+				{Safety: Safe, Statements: []string{"CREATE TABLE IF NOT EXISTS \"public\".\"users\"(\n\"id\" integer NOT NULL\n);"}},
+				{Safety: Safe, Statements: []string{`ALTER TABLE "public"."users" ADD CONSTRAINT "users_pkey" PRIMARY KEY ("id");`}},
+			},
+		},
+		{
+			name: "added columns",
+			source: map[string]Table{"users": {Columns: []Column{
+				{ColumnName: "id", ColumnType: "integer"},
+				{ColumnName: "note", ColumnType: "text", Nullable: true},
+				{ColumnName: "score", ColumnType: "integer", ColumnDefault: &defaultZero},
+				{ColumnName: "email", ColumnType: "text"},
+			}}},
+			target: map[string]Table{"users": {Columns: []Column{{ColumnName: "id", ColumnType: "integer"}}}},
+			want: []Change{
+				{Safety: Safe, Statements: []string{`ALTER TABLE "public"."users" ADD COLUMN "note" text;`}},
+				{Safety: Safe, Statements: []string{`ALTER TABLE "public"."users" ADD COLUMN "score" integer DEFAULT 0 NOT NULL;`}},
+				{Safety: Risky, Statements: []string{`ALTER TABLE "public"."users" ADD COLUMN "email" text NOT NULL;`}},
+			},
+		},
+		{
+			name:   "narrowed type and not null",
+			source: map[string]Table{"users": {Columns: []Column{{ColumnName: "name", ColumnType: "character varying(50)"}}}},
+			target: map[string]Table{"users": {Columns: []Column{{ColumnName: "name", ColumnType: "character varying(255)", Nullable: true}}}},
+			want: []Change{
+				{Safety: Risky, Statements: []string{`ALTER TABLE "public"."users" ALTER COLUMN "name" TYPE character varying(50) USING "name"::character varying(50);`}},
+				{Safety: Risky, Statements: []string{`ALTER TABLE "public"."users" ALTER COLUMN "name" SET NOT NULL;`}},
+			},
+		},
+		{
+			name:   "keys before drops",
+			source: map[string]Table{"users": {PrimaryKey: "id", Columns: []Column{{ColumnName: "id", ColumnType: "integer"}}}},
+			target: map[string]Table{
+				"users": {Columns: []Column{{ColumnName: "id", ColumnType: "integer"}, {ColumnName: "old", ColumnType: "text"}}},
+				"gone":  {Columns: []Column{{ColumnName: "id", ColumnType: "integer"}}},
+			},
+			want: []Change{
+				{Safety: Risky, Statements: []string{`ALTER TABLE "public"."users" ADD CONSTRAINT "users_pkey" PRIMARY KEY ("id");`}},
+				{Safety: Destructive, Statements: []string{`ALTER TABLE "public"."users" DROP COLUMN "old";`}},
+				{Safety: Destructive, Statements: []string{`DROP TABLE IF EXISTS "public"."gone" CASCADE;`}},
+			},
+		},
+		{
+			name: "online foreign key",
+			source: map[string]Table{
+				"orders": {Columns: []Column{{ColumnName: "user_id", ColumnType: "integer"}}, ForeignKeys: []ForeignKey{
+					{SourceColumn: "user_id", ForeignSchemaName: "public", ForeignTableName: "users", ForeignColumnName: "id"},
+				}},
+			},
+			target: map[string]Table{"orders": {Columns: []Column{{ColumnName: "user_id", ColumnType: "integer"}}}},
+			online: true,
+			want: []Change{
+				{
+					Safety:     Risky,
+					Statements: []string{`ALTER TABLE "public"."orders" ADD CONSTRAINT "orders_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "public"."users"("id") NOT VALID;`},
+					After:      []string{`ALTER TABLE "public"."orders" VALIDATE CONSTRAINT "orders_user_id_fkey";`},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := planSchemaChanges(tt.source, tt.target, schema, []SchemaMapping{schema}, tt.online)
+			if len(changes) != len(tt.want) {
+				t.Fatalf("got %v changes, want %v: %+v", len(changes), len(tt.want), changes)
+			}
+			for i, change := range changes {
+				want := tt.want[i]
+				if change.Safety != want.Safety {
+					t.Errorf("change %v (%v): safety = %v, want %v", i, change.Description, change.Safety, want.Safety)
+				}
+				if !slices.Equal(change.Statements, want.Statements) {
+					t.Errorf("change %v: statements = %q, want %q", i, change.Statements, want.Statements)
+				}
+				if !slices.Equal(change.After, want.After) {
+					t.Errorf("change %v: after = %q, want %q", i, change.After, want.After)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+// replaceProgress is the plan of a per table replace and how far it got. it
+// is written to the progress file after every step so a failed run can be
+// resumed. the plan is kept because the target tables it was made from are
+// gone once the drops have run
+type replaceProgress struct {
+	Target               string          `json:"target"`
+	Source               string          `json:"source"`
+	Schemas              []SchemaMapping `json:"schemas"`
+	StartedAt            time.Time       `json:"started_at"`
+	WithData             bool            `json:"with_data"`
+	CopySchemaPrivileges bool            `json:"copy_schema_privileges"`
+	Filter               FilterSettings  `json:"filter"`
+	Masking              string          `json:"masking,omitempty"` // fingerprint of the mask config and secret
+	Steps                []replaceStep   `json:"steps"`
+}
+
+// replaceStep is one piece of work that is committed on its own
+type replaceStep struct {
+	Kind   string        `json:"kind"` // create schema, drop table, create table, add primary key, add foreign keys
+	Schema SchemaMapping `json:"schema"`
+	Table  string        `json:"table,omitempty"`
+	Done   bool          `json:"done"`
+}
+
+func (s replaceStep) describe() string {
+	if s.Table == "" {
+		return fmt.Sprintf("%v %v", s.Kind, s.Schema.Target)
+	}
+	return fmt.Sprintf("%v %v.%v", s.Kind, s.Schema.Target, s.Table)
+}
+
+func describeTarget(targetDbConn *pgx.Conn) string {
+	config := targetDbConn.Config()
+	return fmt.Sprintf("%v:%v/%v", config.Host, config.Port, config.Database)
+}
+
+// planReplaceSteps lays out a per table replace in the same order the single
+// transaction does it
+func planReplaceSteps(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas, missingSchemas []SchemaMapping, filter Filter) ([]replaceStep, error) {
+	spinner.Suffix = " getting table details"
+
+	var steps []replaceStep
+	for _, schema := range missingSchemas {
+		steps = append(steps, replaceStep{Kind: "create schema", Schema: schema})
+	}
+
+	var creates, primaryKeys, foreignKeys []replaceStep
+	for _, schema := range schemas {
+		targetTables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, false, filter)
+		if err != nil {
+			fmt.Println("error while getting target table schema")
+			return nil, err
+		}
+		for _, table := range sortedTableNames(targetTables) {
+			steps = append(steps, replaceStep{Kind: "drop table", Schema: schema, Table: table})
+		}
+
+		sourceTables, err := source.getSchemaDetails(ctx, spinner, schema.Source, true, filter)
+		if err != nil {
+			fmt.Println("error while getting source table schema")
+			return nil, err
+		}
+		for _, table := range sortedTableNames(sourceTables) {
+			creates = append(creates, replaceStep{Kind: "create table", Schema: schema, Table: table})
+			if sourceTables[table].PrimaryKey != "" {
+				primaryKeys = append(primaryKeys, replaceStep{Kind: "add primary key", Schema: schema, Table: table})
+			}
+			if len(sourceTables[table].ForeignKeys) > 0 {
+				foreignKeys = append(foreignKeys, replaceStep{Kind: "add foreign keys", Schema: schema, Table: table})
+			}
+		}
+	}
+
+	// all primary keys before the foreign keys that may point at them
+	return slices.Concat(steps, creates, primaryKeys, foreignKeys), nil
+}
+
+func loadReplaceProgress(path string) (replaceProgress, error) {
+	var progress replaceProgress
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, fmt.Errorf("no progress file at %v, there is nothing to resume", path)
+	}
+	if err != nil {
+		return progress, fmt.Errorf("error while reading progress file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return progress, fmt.Errorf("error while parsing progress file: %w", err)
+	}
+	return progress, nil
+}
+
+func saveReplaceProgress(path string, progress replaceProgress) error {
+	data, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write next to it and rename, so a crash never leaves half a file behind
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("error while writing progress file: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error while writing progress file: %w", err)
+	}
+	return nil
+}
+
+// startReplacePerTable plans a per table replace and saves the plan before
+// anything is changed
+func startReplacePerTable(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas, missingSchemas []SchemaMapping, filter Filter, options ReplaceOptions) (replaceProgress, error) {
+	steps, err := planReplaceSteps(targetDbConn, source, ctx, spinner, schemas, missingSchemas, filter)
+	if err != nil {
+		return replaceProgress{}, err
+	}
+
+	progress := replaceProgress{
+		Target:               describeTarget(targetDbConn),
+		Source:               source.describe(),
+		StartedAt:            time.Now(),
+		WithData:             options.WithData,
+		CopySchemaPrivileges: options.CopySchemaPrivileges,
+		Filter:               filter.Settings(),
+		Masking:              options.Masker.fingerprint(),
+		Steps:                steps,
+		Schemas:              schemas,
+	}
+	if err := saveReplaceProgress(options.ProgressFile, progress); err != nil {
+		return replaceProgress{}, err
+	}
+	return progress, nil
+}
+
+// resumeReplace picks up the progress file of a per table replace that failed
+func resumeReplace(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, filter Filter, options ReplaceOptions) (replaceProgress, error) {
+	progress, err := loadReplaceProgress(options.ProgressFile)
+	if err != nil {
+		return progress, err
+	}
+
+	if target := describeTarget(targetDbConn); progress.Target != target {
+		return progress, fmt.Errorf("%v belongs to a replace of %v, not %v", options.ProgressFile, progress.Target, target)
+	}
+	// the tables are rebuilt from the source with the filter of this run, a
+	// different one would create tables the plan never dropped or skip keys
+	// it expects
+	if !progress.Filter.Equal(filter.Settings()) {
+		return progress, fmt.Errorf("the replace was started with %v, resume it with the same table filters instead of %v", progress.Filter.flags(), filter.Settings().flags())
+	}
+	// the tables that are left would be copied unmasked, or masked with
+	// another key than the ones before them
+	if progress.Masking != options.Masker.fingerprint() {
+		return progress, fmt.Errorf("the replace was started with other masking, resume it with the same --mask-config and --mask-secret")
+	}
+	if progress.Source != source.describe() {
+		fmt.Printf("warning: the replace was started from %v, resuming it from %v\n", progress.Source, source.describe())
+	}
+
+	done := 0
+	next := ""
+	for _, step := range progress.Steps {
+		if step.Done {
+			done++
+		} else if next == "" {
+			next = step.describe()
+		}
+	}
+
+	fmt.Printf("resuming the replace of %v started at %v\n", progress.Target, progress.StartedAt.Local().Format(time.DateTime))
+	fmt.Printf("%v of %v steps are done, continuing with: %v\n", done, len(progress.Steps), next)
+
+	if !options.Yes && !askYesNo("continue the replace?") {
+		return progress, ErrAborted
+	}
+	return progress, nil
+}
+
+// runReplaceSteps runs every step that is not done yet in a transaction of its
+// own, and ticks it off in the progress file once it is committed. the file is
+// removed when the last step is done
+func runReplaceSteps(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, filter Filter, progress replaceProgress, options ReplaceOptions) error {
+	var sourceDbConn *pgx.Conn
+	if live, ok := source.(liveSchema); ok {
+		sourceDbConn = live.conn
+	}
+	if sourceDbConn == nil && progress.WithData {
+		return fmt.Errorf("the replace copies data, it needs a live source database, not a file")
+	}
+	if sourceDbConn == nil && progress.CopySchemaPrivileges {
+		return fmt.Errorf("the replace copies schema privileges, it needs a live source database, not a file")
+	}
+
+	startTime := time.Now()
+	spinner.Start()
+
+	// the rows of every table come from one snapshot, as far as this run
+	// goes. a resumed run reads a newer one
+	var sourceRows querier = sourceDbConn
+	if progress.WithData {
+		sourceTx, err := sourceDbConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+		if err != nil {
+			spinner.Stop()
+			fmt.Println("error while beginning source transaction")
+			return err
+		}
+		defer sourceTx.Rollback(ctx)
+		sourceRows = sourceTx
+	}
+
+	sourceTableStructures := make(map[SchemaMapping]map[string]Table)
+	for _, schema := range progress.Schemas {
+		sourceTables, err := source.getSchemaDetails(ctx, spinner, schema.Source, true, filter)
+		if err != nil {
+			spinner.Stop()
+			fmt.Println("error while getting source table schema")
+			return err
+		}
+		sourceTableStructures[schema] = sourceTables
+	}
+
+	if progress.WithData {
+		if err := options.Masker.checkTables(sourceTableStructures, dependencyOrder(sourceTableStructures, progress.Schemas)); err != nil {
+			spinner.Stop()
+			return err
+		}
+	}
+
+	var numOfRowsCopied int64
+	for i, step := range progress.Steps {
+		if step.Done {
+			continue
+		}
+		spinner.Suffix = fmt.Sprintf(" %v (%v/%v)", step.describe(), i+1, len(progress.Steps))
+
+		err := options.Timeouts.retryOnLockTimeout(spinner, func() error {
+			tx, err := targetDbConn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback(ctx) // rollback if we dont commit!!!!!!
+
+			if err := options.Timeouts.apply(tx, ctx); err != nil {
+				return err
+			}
+
+			copied, err := runReplaceStep(tx, sourceDbConn, sourceRows, ctx, spinner, step, sourceTableStructures, progress, filter, options.Masker)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Commit(ctx); err != nil {
+				return err
+			}
+			numOfRowsCopied += copied
+			return nil
+		})
+		if err != nil {
+			spinner.Stop()
+			fmt.Printf("failed at step %v of %v: %v\n", i+1, len(progress.Steps), step.describe())
+			fmt.Printf("the steps before it are committed, fix the problem and run replace again with --resume to continue from here\n")
+			return fmt.Errorf("%v: %w", step.describe(), err)
+		}
+
+		progress.Steps[i].Done = true
+		if err := saveReplaceProgress(options.ProgressFile, progress); err != nil {
+			spinner.Stop()
+			fmt.Printf("step %v (%v) is committed but could not be recorded, it has to be marked done by hand before resuming\n", i+1, step.describe())
+			return err
+		}
+	}
+	spinner.Stop()
+
+	if err := os.Remove(options.ProgressFile); err != nil {
+		fmt.Println("error while removing progress file")
+		return err
+	}
+
+	if progress.WithData {
+		fmt.Printf("\nReplaced in %v steps, copying %v rows, in %v seconds\n", len(progress.Steps), numOfRowsCopied, time.Since(startTime))
+	} else {
+		fmt.Printf("\nReplaced in %v steps in %v seconds\n", len(progress.Steps), time.Since(startTime))
+	}
+	return nil
+}
+
+func runReplaceStep(tx pgx.Tx, sourceDbConn *pgx.Conn, sourceRows querier, ctx context.Context, spinner *spinner.Spinner, step replaceStep, sourceTableStructures map[SchemaMapping]map[string]Table, progress replaceProgress, filter Filter, masker *Masker) (int64, error) {
+	if step.Kind == "create schema" {
+		queries, err := generateCreateSchemaQueries(sourceDbConn, ctx, step.Schema, progress.CopySchemaPrivileges)
+		if err != nil {
+			return 0, err
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(ctx, query); err != nil {
+				return 0, err
+			}
+		}
+		return 0, nil
+	}
+
+	if step.Kind == "drop table" {
+		_, err := tx.Exec(ctx, generateDropTableQuery(step.Schema.Target, step.Table))
+		return 0, err
+	}
+
+	table, exists := sourceTableStructures[step.Schema][step.Table]
+	if !exists {
+		return 0, fmt.Errorf("table %v.%v is no longer in the source", step.Schema.Source, step.Table)
+	}
+
+	switch step.Kind {
+	case "create table":
+		if _, err := tx.Exec(ctx, generateCreateTableWithDefaultsQuery(step.Schema.Target, step.Table, table.Columns)); err != nil {
+			return 0, err
+		}
+		if !progress.WithData {
+			return 0, nil
+		}
+		// the rows go in with the table, keys come later like in a single transaction
+		ref := tableRef{Schema: step.Schema, Table: step.Table}
+		copied, err := copyTables(tx, sourceRows, ctx, spinner, sourceTableStructures, []tableRef{ref}, nil, masker)
+		if err != nil {
+			return 0, err
+		}
+		return copied, restartSequences(tx, ctx, spinner, []tableRef{ref})
+
+	case "add primary key":
+		_, err := tx.Exec(ctx, generatePrimaryKeyQuery(step.Schema.Target, step.Table, table.PrimaryKey))
+		return 0, err
+
+	case "add foreign keys":
+		for _, fk := range table.ForeignKeys {
+			if filter.leavesOut(fk, progress.Schemas) {
+				fmt.Printf("warning: skipping foreign key on %v.%v(%v), it references %v.%v which is filtered out\n", step.Schema.Target, step.Table, fk.SourceColumn, fk.ForeignSchemaName, fk.ForeignTableName)
+				continue
+			}
+
+			// references to schemas that are not part of this run are left as is
+			foreignSchema := mappedTargetSchema(fk.ForeignSchemaName, progress.Schemas)
+			if _, err := tx.Exec(ctx, generateForeignKeyQuery(step.Schema.Target, step.Table, foreignSchema, fk)); err != nil {
+				return 0, fmt.Errorf("column %v referencing %v.%v(%v): %w", fk.SourceColumn, foreignSchema, fk.ForeignTableName, fk.ForeignColumnName, err)
+			}
+		}
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("unknown step '%v' in progress file", step.Kind)
+}
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// a database can mark itself as protected, so it stays protected no matter
+// which .env file is used to connect to it
+const (
+	protectedMarkerTable   = "gograte_protected"
+	protectedMarkerComment = "gograte:protected"
+)
+
+// GuardProtectedTarget refuses to let destructive commands loose on a target
+// that is protected, either by matching one of the patterns (globs or /regex/
+// against the host, the database name or host/database) or by carrying a
+// marker. override plus typing the host name lets it through anyway
+func GuardProtectedTarget(targetDbConn *pgx.Conn, ctx context.Context, method string, patterns []string, override bool) error {
+	config := targetDbConn.Config()
+
+	reason := ""
+	for _, pattern := range patterns {
+		re, err := compileTablePattern(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid protected target pattern: %w", err)
+		}
+
+		for _, candidate := range []string{config.Host, config.Database, config.Host + "/" + config.Database} {
+			if re.MatchString(candidate) {
+				reason = fmt.Sprintf("matches the protected pattern '%v'", pattern)
+				break
+			}
+		}
+		if reason != "" {
+			break
+		}
+	}
+
+	if reason == "" {
+		var markedByTable, markedByComment bool
+		err := targetDbConn.QueryRow(ctx, `
+			SELECT
+				EXISTS (SELECT 1 FROM pg_class WHERE relname = $1 AND relkind IN ('r', 'p', 'v')),
+				COALESCE(shobj_description((SELECT oid FROM pg_database WHERE datname = current_database()), 'pg_database'), '') LIKE '%' || $2 || '%';
+		`, protectedMarkerTable, protectedMarkerComment).Scan(&markedByTable, &markedByComment)
+		if err != nil {
+			fmt.Println("error while checking target for a protection marker")
+			return err
+		}
+
+		if markedByTable {
+			reason = fmt.Sprintf("has a %v table", protectedMarkerTable)
+		} else if markedByComment {
+			reason = fmt.Sprintf("has '%v' in its comment", protectedMarkerComment)
+		}
+	}
+
+	if reason == "" {
+		return nil
+	}
+
+	target := config.Host + "/" + config.Database
+	if !override {
+		return fmt.Errorf("target %v is protected, it %v. refusing to run %v without --i-know-this-is-prod", target, reason, method)
+	}
+
+	// no --yes for this one, someone has to be at the keyboard
+	fmt.Printf("target %v is PROTECTED, it %v\n", target, reason)
+	if !askToType(fmt.Sprintf("you are about to run %v against a protected target. type its host name to continue", method), config.Host) {
+		return ErrAborted
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+func renderDiffReport(w io.Writer, report DiffReport, format string) error {
+	switch strings.ToLower(format) {
+	case "", "text":
+		return renderTextReport(w, report)
+	case "json":
+		return renderJSONReport(w, report)
+	case "markdown", "md":
+		return renderMarkdownReport(w, report)
+	case "html":
+		return renderHTMLReport(w, report)
+	default:
+		return fmt.Errorf("'%v' is not a supported diff format", format)
+	}
+}
+
+func renderTextReport(w io.Writer, report DiffReport) error {
+	for i, diff := range report.Schemas {
+		// a single schema keeps the plain layout, multiple get a header each
+		if len(report.Schemas) > 1 {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "=== schema %s -> %s ===\n", diff.SourceSchema, diff.TargetSchema)
+		}
+		renderTextSchemaDiff(w, diff)
+	}
+
+	return nil
+}
+
+func renderTextSchemaDiff(w io.Writer, diff SchemaDiff) {
+	fmt.Fprintf(w, "new tables (%v):\n", len(diff.NewTables))
+	if len(diff.NewTables) > 0 {
+		for _, table := range diff.NewTables {
+			fmt.Fprintf(w, "\t+ %s\n", table)
+		}
+	} else {
+		fmt.Fprintln(w, "  none")
+	}
+
+	fmt.Fprintf(w, "\ndeleted tables (%v):\n", len(diff.RemovedTables))
+	if len(diff.RemovedTables) > 0 {
+		for _, table := range diff.RemovedTables {
+			fmt.Fprintf(w, "\t- %s\n", table)
+		}
+	} else {
+		fmt.Fprintln(w, "  none")
+	}
+
+	fmt.Fprintf(w, "\ncolumn changes (%v):\n", diff.NumOfColumnChanges())
+	for _, changes := range diff.ColumnChanges {
+		fmt.Fprintf(w, "  %s:\n", changes.Table)
+		for _, col := range changes.AddedColumns {
+			fmt.Fprintf(w, "    + %s\n", col)
+		}
+		for _, col := range changes.RemovedColumns {
+			fmt.Fprintf(w, "    - %s\n", col)
+		}
+	}
+	if len(diff.ColumnChanges) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+
+	fmt.Fprintf(w, "\nchanges (%v safe, %v risky, %v destructive):\n", diff.NumOfChanges(Safe), diff.NumOfChanges(Risky), diff.NumOfChanges(Destructive))
+	for _, change := range diff.Changes {
+		fmt.Fprintf(w, "  %s\n", changeLabel(change))
+		for _, line := range outsideTransaction(change) {
+			fmt.Fprintf(w, "      %s\n", line)
+		}
+	}
+	if len(diff.Changes) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+}
+
+func renderJSONReport(w io.Writer, report DiffReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func renderMarkdownReport(w io.Writer, report DiffReport) error {
+	for _, diff := range report.Schemas {
+		renderMarkdownSchemaDiff(w, diff)
+	}
+
+	return nil
+}
+
+func renderMarkdownSchemaDiff(w io.Writer, diff SchemaDiff) {
+	// diff code blocks so github/gitlab color the +/- lines in pr comments
+	fmt.Fprintf(w, "## Schema diff: `%s` → `%s`\n\n", diff.SourceSchema, diff.TargetSchema)
+
+	if !diff.HasChanges() {
+		fmt.Fprint(w, "No differences found.\n\n")
+		return
+	}
+
+	fmt.Fprintf(w, "### New tables (%v)\n\n", len(diff.NewTables))
+	if len(diff.NewTables) > 0 {
+		fmt.Fprintln(w, "```diff")
+		for _, table := range diff.NewTables {
+			fmt.Fprintf(w, "+ %s\n", table)
+		}
+		fmt.Fprint(w, "```\n\n")
+	} else {
+		fmt.Fprint(w, "_none_\n\n")
+	}
+
+	fmt.Fprintf(w, "### Deleted tables (%v)\n\n", len(diff.RemovedTables))
+	if len(diff.RemovedTables) > 0 {
+		fmt.Fprintln(w, "```diff")
+		for _, table := range diff.RemovedTables {
+			fmt.Fprintf(w, "- %s\n", table)
+		}
+		fmt.Fprint(w, "```\n\n")
+	} else {
+		fmt.Fprint(w, "_none_\n\n")
+	}
+
+	fmt.Fprintf(w, "### Column changes (%v)\n\n", diff.NumOfColumnChanges())
+	if len(diff.ColumnChanges) == 0 {
+		fmt.Fprint(w, "_none_\n\n")
+	}
+	for _, changes := range diff.ColumnChanges {
+		fmt.Fprintf(w, "#### `%s`\n\n```diff\n", changes.Table)
+		for _, col := range changes.AddedColumns {
+			fmt.Fprintf(w, "+ %s\n", col)
+		}
+		for _, col := range changes.RemovedColumns {
+			fmt.Fprintf(w, "- %s\n", col)
+		}
+		fmt.Fprint(w, "```\n\n")
+	}
+
+	fmt.Fprintf(w, "### Changes (%v safe, %v risky, %v destructive)\n\n", diff.NumOfChanges(Safe), diff.NumOfChanges(Risky), diff.NumOfChanges(Destructive))
+	if len(diff.Changes) == 0 {
+		fmt.Fprint(w, "_none_\n\n")
+		return
+	}
+	fmt.Fprintln(w, "| Safety | Change | Why | Outside the transaction |")
+	fmt.Fprintln(w, "|--------|--------|-----|-------------------------|")
+	for _, change := range diff.Changes {
+		var outside []string
+		for _, line := range outsideTransaction(change) {
+			outside = append(outside, "`"+strings.ReplaceAll(line, "|", "\\|")+"`")
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s |\n", markdownSafety[change.Safety], change.Description, change.Reason, strings.Join(outside, "<br>"))
+	}
+	fmt.Fprintln(w)
+}
+
+var markdownSafety = map[Safety]string{
+	Safe:        "✅ safe",
+	Risky:       "⚠️ risky",
+	Destructive: "🛑 destructive",
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>gograte schema diff</title>
+<style>
+	body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2rem; color: #1f2328; }
+	h1 { font-size: 1.4rem; }
+	h2 { font-size: 1.2rem; margin-top: 2rem; border-bottom: 1px solid #d0d7de; }
+	h3 { font-size: 1rem; margin-top: 1.5rem; }
+	code, li.col { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; }
+	ul { list-style: none; padding-left: 1rem; }
+	.added { color: #1a7f37; background: #dafbe1; }
+	.removed { color: #cf222e; background: #ffebe9; }
+	.none { color: #656d76; font-style: italic; }
+	.safety { font-weight: 600; text-transform: uppercase; font-size: 0.8rem; padding: 0 0.3rem; border-radius: 4px; }
+	.safety-safe { color: #1a7f37; background: #dafbe1; }
+	.safety-risky { color: #9a6700; background: #fff8c5; }
+	.safety-destructive { color: #cf222e; background: #ffebe9; }
+	.reason { color: #656d76; }
+	.outside { color: #656d76; font-size: 0.8rem; margin-left: 1rem; }
+	details { margin: 0.5rem 0; border: 1px solid #d0d7de; border-radius: 6px; padding: 0.5rem 1rem; }
+	summary { cursor: pointer; font-weight: 600; }
+</style>
+</head>
+<body>
+<h1>Schema diff</h1>
+{{range .Schemas}}
+<section>
+<h2>Schema <code>{{.SourceSchema}}</code> &rarr; <code>{{.TargetSchema}}</code></h2>
+
+<h3>New tables ({{len .NewTables}})</h3>
+{{if .NewTables}}<ul>{{range .NewTables}}
+	<li class="col added">+ {{.}}</li>{{end}}
+</ul>{{else}}<p class="none">none</p>{{end}}
+
+<h3>Deleted tables ({{len .RemovedTables}})</h3>
+{{if .RemovedTables}}<ul>{{range .RemovedTables}}
+	<li class="col removed">- {{.}}</li>{{end}}
+</ul>{{else}}<p class="none">none</p>{{end}}
+
+<h3>Column changes ({{.NumOfColumnChanges}})</h3>
+{{if .ColumnChanges}}{{range .ColumnChanges}}<details open>
+	<summary>{{.Table}} (<span class="added">+{{len .AddedColumns}}</span> / <span class="removed">-{{len .RemovedColumns}}</span>)</summary>
+	<ul>{{range .AddedColumns}}
+		<li class="col added">+ {{.}}</li>{{end}}{{range .RemovedColumns}}
+		<li class="col removed">- {{.}}</li>{{end}}
+	</ul>
+</details>
+{{end}}{{else}}<p class="none">none</p>{{end}}
+
+<h3>Changes ({{.NumOfChanges "safe"}} safe, {{.NumOfChanges "risky"}} risky, {{.NumOfChanges "destructive"}} destructive)</h3>
+{{if .Changes}}<ul>{{range .Changes}}
+	<li><span class="safety safety-{{.Safety}}">{{.Safety}}</span> {{.Description}}{{if .Reason}} <span class="reason">({{.Reason}})</span>{{end}}{{range .Before}}
+		<br><code class="outside">before the transaction: {{.}}</code>{{end}}{{range .After}}
+		<br><code class="outside">after the transaction: {{.}}</code>{{end}}</li>{{end}}
+</ul>{{else}}<p class="none">none</p>{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+func renderHTMLReport(w io.Writer, report DiffReport) error {
+	return htmlReportTemplate.Execute(w, report)
+}
@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderDiffReport(t *testing.T) {
+	report := DiffReport{Schemas: []SchemaDiff{{
+		SourceSchema:  "public",
+		TargetSchema:  "public",
+		NewTables:     []string{"a<b&c"},
+		RemovedTables: []string{"gone"},
+		ColumnChanges: []TableColumnChanges{{Table: "users", AddedColumns: []string{"email"}, RemovedColumns: []string{"nickname"}}},
+		Changes: []Change{
+			{Schema: "public", Table: "a<b&c", Safety: Safe, Description: "create table a<b&c", Statements: []string{`CREATE TABLE "public"."a<b&c"();`}},
+			{Schema: "public", Table: "gone", Safety: Destructive, Description: "drop table gone", Reason: "its rows are lost"},
+			{Schema: "public", Table: "users", Safety: Risky, Description: "add foreign key", After: []string{"VALIDATE a|b"}},
+		},
+	}}}
+
+	tests := []struct {
+		format  string
+		want    []string
+		notWant []string
+	}{
+		{
+			format: "text",
+			want: []string{
+				"new tables (1):\n\t+ a<b&c\n",
+				"deleted tables (1):\n\t- gone\n",
+				"column changes (2):\n  users:\n    + email\n    - nickname\n",
+				"changes (1 safe, 1 risky, 1 destructive):\n",
+				"  [DESTRUCTIVE] drop table gone (its rows are lost)\n",
+				"      after the transaction: VALIDATE a|b\n",
+			},
+			notWant: []string{"=== schema"},
+		},
+		{
+			format: "markdown",
+			want: []string{
+				"## Schema diff: `public` → `public`\n",
+				"### New tables (1)\n\n```diff\n+ a<b&c\n```\n",
+				"#### `users`\n\n```diff\n+ email\n- nickname\n```\n",
+				"| 🛑 destructive | drop table gone | its rows are lost |  |\n",
+				"| ⚠️ risky | add foreign key |  | `after the transaction: VALIDATE a\\|b` |\n",
+			},
+		},
+		{
+			format: "html",
+			want: []string{
+				`<li class="col added">+ a&lt;b&amp;c</li>`,
+				`<li class="col removed">- gone</li>`,
+				`<span class="safety safety-destructive">destructive</span> drop table gone <span class="reason">(its rows are lost)</span>`,
+				`<code class="outside">after the transaction: VALIDATE a|b</code>`,
+			},
+			notWant: []string{"a<b&c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := renderDiffReport(&out, report, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("report does not contain %q:\n%v", want, out.String())
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out.String(), notWant) {
+					t.Errorf("report contains %q:\n%v", notWant, out.String())
+				}
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := renderDiffReport(&out, report, "json"); err != nil {
+			t.Fatal(err)
+		}
+		var got DiffReport
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, report) {
+			t.Errorf("json report reads back as %+v, want %+v", got, report)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := renderDiffReport(&out, report, "yaml"); err == nil {
+			t.Error("rendering a yaml report did not fail")
+		}
+	})
+}
+
+func TestRenderTextReportSchemaHeaders(t *testing.T) {
+	report := DiffReport{Schemas: []SchemaDiff{
+		{SourceSchema: "app", TargetSchema: "app_copy"},
+		{SourceSchema: "billing", TargetSchema: "billing"},
+	}}
+
+	var out bytes.Buffer
+	if err := renderDiffReport(&out, report, "text"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"=== schema app -> app_copy ===\n", "\n=== schema billing -> billing ===\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%v", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+// PrepareRollback works out, before replace or sync change anything, the sql
+// that puts the target tables back the way they are now and keeps it with the
+// run. it has to be called while holding the target lock so nothing changes
+// in between
+func (a *Audit) PrepareRollback(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter, method string) error {
+	spinner.Start()
+	defer spinner.Stop()
+
+	var script string
+	var err error
+	if method == "sync" {
+		script, err = generateSyncRollback(targetDbConn, source, ctx, spinner, schemas, filter)
+	} else {
+		script, err = generateReplaceRollback(targetDbConn, source, ctx, spinner, schemas, filter)
+	}
+	if err != nil {
+		fmt.Println("error while generating rollback script, nothing was changed")
+		return err
+	}
+
+	a.run.Rollback = script
+	return nil
+}
+
+// generateReplaceRollback drops whatever replace is going to create and
+// recreates the target tables as they are now, with their column defaults and
+// primary and foreign keys. the rows are not part of it
+func generateReplaceRollback(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter) (string, error) {
+	// keys are part of what gets dropped, even when replace wont recreate them
+	before := filter
+	before.SkipPrimaryKeys = false
+	before.SkipForeignKeys = false
+
+	var drops, creates, primaryKeys, foreignKeys []string
+	for _, schema := range schemas {
+		targetTables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, true, before)
+		if err != nil {
+			fmt.Println("error while getting target table schema")
+			return "", err
+		}
+
+		sourceTables, err := source.getSchemaDetails(ctx, spinner, schema.Source, false, filter)
+		if err != nil {
+			fmt.Println("error while getting source table schema")
+			return "", err
+		}
+
+		tables := sortedTableNames(targetTables)
+		for _, table := range sortedTableNames(sourceTables) {
+			if !slices.Contains(tables, table) {
+				tables = append(tables, table)
+			}
+		}
+		for _, table := range tables {
+			drops = append(drops, generateDropTableQuery(schema.Target, table))
+		}
+
+		for _, table := range sortedTableNames(targetTables) {
+			creates = append(creates, generateCreateTableWithDefaultsQuery(schema.Target, table, targetTables[table].Columns))
+			if pk := targetTables[table].PrimaryKey; pk != "" {
+				primaryKeys = append(primaryKeys, generatePrimaryKeyQuery(schema.Target, table, pk))
+			}
+		}
+		for _, table := range sortedTableNames(targetTables) {
+			for _, fk := range targetTables[table].ForeignKeys {
+				foreignKeys = append(foreignKeys, generateForeignKeyQuery(schema.Target, table, fk.ForeignSchemaName, fk))
+			}
+		}
+	}
+
+	// all primary keys before the foreign keys that may point at them
+	return renderRollback(slices.Concat(drops, creates, primaryKeys, foreignKeys)), nil
+}
+
+// generateSyncRollback plans the way back from what sync leaves behind to what
+// the target is now. sync keeps the rows, so does its rollback. columns and
+// tables sync drops come back empty
+func generateSyncRollback(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter) (string, error) {
+	var statements []string
+	for _, schema := range schemas {
+		targetTables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, true, filter)
+		if err != nil {
+			fmt.Println("error while getting target table schema")
+			return "", err
+		}
+
+		sourceTables, err := source.getSchemaDetails(ctx, spinner, schema.Source, true, filter)
+		if err != nil {
+			fmt.Println("error while getting source table schema")
+			return "", err
+		}
+
+		statements = append(statements, planSyncRollback(sourceTables, targetTables, schema, schemas)...)
+	}
+
+	return renderRollback(statements), nil
+}
+
+// planSyncRollback is the way back for the tables of a single schema
+func planSyncRollback(sourceTables, targetTables map[string]Table, schema SchemaMapping, schemas []SchemaMapping) []string {
+	var statements []string
+	after := expectedAfterSync(sourceTables, targetTables, schemas)
+
+	// keys only ever get added by sync, under the names planKeyChanges gives
+	// them. they go first so nothing they guard is in the way of the changes
+	// below
+	for _, table := range sortedTableNames(after) {
+		before, existed := targetTables[table]
+		if !existed {
+			continue // dropped with the table
+		}
+
+		if after[table].PrimaryKey != "" && before.PrimaryKey == "" {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", quoteIdentifier(schema.Target, table), quoteIdentifier(primaryKeyName(table))))
+		}
+		for _, fk := range after[table].ForeignKeys {
+			if !slices.Contains(before.ForeignKeys, fk) {
+				statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", quoteIdentifier(schema.Target, table), quoteIdentifier(foreignKeyName(table, fk.SourceColumn))))
+			}
+		}
+	}
+
+	// both sides are in the target schema now
+	back := SchemaMapping{Source: schema.Target, Target: schema.Target}
+	for _, change := range planSchemaChanges(targetTables, after, back, targetSchemas(schemas), false) {
+		statements = append(statements, change.Statements...)
+	}
+	return statements
+}
+
+// expectedAfterSync is what the target tables look like once sync has run. a
+// table keeps the keys it already has next to the ones sync adds
+func expectedAfterSync(sourceTables, targetTables map[string]Table, schemas []SchemaMapping) map[string]Table {
+	after := make(map[string]Table)
+	for name, sourceTable := range sourceTables {
+		table := Table{PrimaryKey: sourceTable.PrimaryKey, Columns: sourceTable.Columns}
+		for _, fk := range sourceTable.ForeignKeys {
+			fk.ForeignSchemaName = mappedTargetSchema(fk.ForeignSchemaName, schemas)
+			table.ForeignKeys = append(table.ForeignKeys, fk)
+		}
+
+		if targetTable, exists := targetTables[name]; exists {
+			if targetTable.PrimaryKey != "" {
+				table.PrimaryKey = targetTable.PrimaryKey
+			}
+			// a dropped column takes its foreign key with it
+			columns := columnNames(table.Columns)
+			for _, fk := range targetTable.ForeignKeys {
+				if !slices.Contains(table.ForeignKeys, fk) && slices.Contains(columns, fk.SourceColumn) {
+					table.ForeignKeys = append(table.ForeignKeys, fk)
+				}
+			}
+		}
+
+		after[name] = table
+	}
+	return after
+}
+
+func targetSchemas(schemas []SchemaMapping) []SchemaMapping {
+	mapped := make([]SchemaMapping, 0, len(schemas))
+	for _, schema := range schemas {
+		mapped = append(mapped, SchemaMapping{Source: schema.Target, Target: schema.Target})
+	}
+	return mapped
+}
+
+func renderRollback(statements []string) string {
+	if len(statements) == 0 {
+		return ""
+	}
+	return strings.Join(statements, "\n") + "\n"
+}
+
+func RollbackMethod(targetDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, runID, historySchema, file string, yes bool) error {
+	// puts the target back the way it was before a replace or sync, with the
+	// script that was saved with the run. rows replace threw away stay gone,
+	// that is what its backup is for
+
+	if runID == "" {
+		return fmt.Errorf("rollback needs the id of the run to roll back, see history")
+	}
+
+	runs, err := loadAuditRuns(targetDbConn, ctx, historySchema, file)
+	if err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(runs, func(run AuditRun) bool { return run.ID == runID })
+	if index < 0 {
+		return fmt.Errorf("no run with id '%v' in the audit log", runID)
+	}
+	run := runs[index]
+
+	if run.Rollback == "" {
+		return fmt.Errorf("run %v (%v) has no rollback script, only replace and sync that change something save one", run.ID, run.Command)
+	}
+	if run.Status == "aborted" {
+		return fmt.Errorf("run %v was aborted before it changed anything, there is nothing to roll back", run.ID)
+	}
+
+	// anything that ran after it is rolled back as well, whether it was meant to or not
+	var later []string
+	for _, other := range runs[:index] {
+		if other.Status != "aborted" && len(other.Statements) > 0 && !strings.HasPrefix(other.Command, "rollback") {
+			later = append(later, fmt.Sprintf("%v (%v)", other.ID, other.Command))
+		}
+	}
+
+	fmt.Printf("rollback of %v (%v by %v at %v):\n\n", run.ID, run.Command, run.OSUser, run.StartedAt.Local().Format(time.DateTime))
+	fmt.Println(run.Rollback)
+	if run.Status == "failed" {
+		fmt.Println("the run failed, if it did not get to commit there is nothing to roll back")
+	}
+	if len(later) > 0 {
+		fmt.Println("these runs came after it and may be undone too: " + strings.Join(later, ", "))
+	}
+	if !yes && !askYesNo("run this rollback against the target?") {
+		return ErrAborted
+	}
+
+	startTime := time.Now()
+	spinner.Start()
+	spinner.Suffix = " rolling back " + run.ID
+
+	tx, err := targetDbConn.Begin(ctx)
+	if err != nil {
+		spinner.Stop()
+		fmt.Println("error while beginning transaction")
+		return err
+	}
+	defer tx.Rollback(ctx) // rollback if we dont commit!!!!!!
+
+	// no arguments means the simple protocol, which runs every statement in the script
+	if _, err := tx.Exec(ctx, run.Rollback); err != nil {
+		spinner.Stop()
+		fmt.Println("error while running rollback script, nothing was changed")
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		spinner.Stop()
+		fmt.Println("error while committing rollback")
+		return err
+	}
+	spinner.Stop()
+
+	fmt.Printf("\nRolled back %v in %v seconds\n", run.ID, time.Since(startTime))
+	return nil
+}
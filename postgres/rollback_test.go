@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPlanSyncRollback(t *testing.T) {
+	schema := SchemaMapping{Source: "public", Target: "public"}
+	id := Column{ColumnName: "id", ColumnType: "integer"}
+	userID := Column{ColumnName: "user_id", ColumnType: "integer"}
+	userFk := ForeignKey{SourceColumn: "user_id", ForeignSchemaName: "public", ForeignTableName: "users", ForeignColumnName: "id"}
+
+	tests := []struct {
+		name   string
+		source map[string]Table
+		target map[string]Table
+		want   []string
+	}{
+		{
+			name:   "nothing changed",
+			source: map[string]Table{"users": {PrimaryKey: "id", Columns: []Column{id}}},
+			target: map[string]Table{"users": {PrimaryKey: "id", Columns: []Column{id}}},
+		},
+		{
+			name:   "added table",
+			source: map[string]Table{"users": {PrimaryKey: "id", Columns: []Column{id}}},
+			target: map[string]Table{},
+			want:   []string{`DROP TABLE IF EXISTS "public"."users" CASCADE;`},
+		},
+		{
+			name:   "added column",
+			source: map[string]Table{"users": {Columns: []Column{id, {ColumnName: "note", ColumnType: "text", Nullable: true}}}},
+			target: map[string]Table{"users": {Columns: []Column{id}}},
+			want:   []string{`ALTER TABLE "public"."users" DROP COLUMN "note";`},
+		},
+		{
+			name:   "dropped column comes back empty",
+			source: map[string]Table{"users": {Columns: []Column{id}}},
+			target: map[string]Table{"users": {Columns: []Column{id, {ColumnName: "note", ColumnType: "text", Nullable: true}}}},
+			want:   []string{`ALTER TABLE "public"."users" ADD COLUMN "note" text;`},
+		},
+		{
+			name:   "changed type",
+			source: map[string]Table{"users": {Columns: []Column{{ColumnName: "id", ColumnType: "bigint"}}}},
+			target: map[string]Table{"users": {Columns: []Column{id}}},
+			want:   []string{`ALTER TABLE "public"."users" ALTER COLUMN "id" TYPE integer USING "id"::integer;`},
+		},
+		{
+			name: "added keys are dropped by name",
+			source: map[string]Table{
+				"users":  {PrimaryKey: "id", Columns: []Column{id}},
+				"orders": {Columns: []Column{userID}, ForeignKeys: []ForeignKey{userFk}},
+			},
+			target: map[string]Table{
+				"users":  {Columns: []Column{id}},
+				"orders": {Columns: []Column{userID}},
+			},
+			want: []string{
+				`ALTER TABLE "public"."orders" DROP CONSTRAINT "orders_user_id_fkey";`,
+				`ALTER TABLE "public"."users" DROP CONSTRAINT "users_pkey";`,
+			},
+		},
+		{
+			name:   "keys the target already had stay",
+			source: map[string]Table{"orders": {PrimaryKey: "id", Columns: []Column{id, userID}, ForeignKeys: []ForeignKey{userFk}}},
+			target: map[string]Table{"orders": {PrimaryKey: "id", Columns: []Column{id, userID}, ForeignKeys: []ForeignKey{userFk}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planSyncRollback(tt.source, tt.target, schema, []SchemaMapping{schema})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("planSyncRollback() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectedAfterSync(t *testing.T) {
+	schemas := []SchemaMapping{{Source: "app", Target: "app_copy"}}
+	id := Column{ColumnName: "id", ColumnType: "integer"}
+	userID := Column{ColumnName: "user_id", ColumnType: "integer"}
+
+	source := map[string]Table{
+		"orders": {PrimaryKey: "id", Columns: []Column{id, userID}, ForeignKeys: []ForeignKey{
+			{SourceColumn: "user_id", ForeignSchemaName: "app", ForeignTableName: "users", ForeignColumnName: "id"},
+		}},
+	}
+	target := map[string]Table{
+		"orders": {PrimaryKey: "user_id", Columns: []Column{id, userID, {ColumnName: "old_id", ColumnType: "integer"}}, ForeignKeys: []ForeignKey{
+			{SourceColumn: "old_id", ForeignSchemaName: "app_copy", ForeignTableName: "legacy", ForeignColumnName: "id"},
+		}},
+	}
+
+	after := expectedAfterSync(source, target, schemas)["orders"]
+
+	if after.PrimaryKey != "user_id" {
+		t.Errorf("primary key = %v, want the one the target has", after.PrimaryKey)
+	}
+	want := []ForeignKey{{SourceColumn: "user_id", ForeignSchemaName: "app_copy", ForeignTableName: "users", ForeignColumnName: "id"}}
+	if !slices.Equal(after.ForeignKeys, want) {
+		t.Errorf("foreign keys = %v, want %v", after.ForeignKeys, want)
+	}
+}
@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type schemaGrant struct {
+	Grantee       string `db:"grantee"`
+	PrivilegeType string `db:"privilege_type"`
+	IsGrantable   bool   `db:"is_grantable"`
+}
+
+func schemaExists(dbConn *pgx.Conn, ctx context.Context, schema string) (bool, error) {
+	var exists bool
+	err := dbConn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1);`, schema).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// missingTargetSchemas returns the target schemas that do not exist yet
+func missingTargetSchemas(targetDbConn *pgx.Conn, ctx context.Context, schemas []SchemaMapping) ([]SchemaMapping, error) {
+	var missing []SchemaMapping
+	for _, schema := range schemas {
+		exists, err := schemaExists(targetDbConn, ctx, schema.Target)
+		if err != nil {
+			fmt.Println("error while checking if target schema exists")
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, schema)
+		}
+	}
+	return missing, nil
+}
+
+// generateCreateSchemaQueries builds the statements that create a target schema.
+// when copyPrivileges is set the owner and grants of the source schema are
+// carried over as well, so those roles have to exist on the target
+func generateCreateSchemaQueries(sourceDbConn *pgx.Conn, ctx context.Context, schema SchemaMapping, copyPrivileges bool) ([]string, error) {
+	queries := []string{fmt.Sprintf("CREATE SCHEMA %s;", quoteIdentifier(schema.Target))}
+
+	if !copyPrivileges {
+		return queries, nil
+	}
+
+	var owner string
+	err := sourceDbConn.QueryRow(ctx, `
+		SELECT pg_get_userbyid(nspowner)
+		FROM pg_namespace
+		WHERE nspname = $1;
+	`, schema.Source).Scan(&owner)
+	if err != nil {
+		fmt.Println("error while getting owner of source schema " + schema.Source)
+		return nil, err
+	}
+	queries = append(queries, fmt.Sprintf("ALTER SCHEMA %s OWNER TO %s;", quoteIdentifier(schema.Target), quoteIdentifier(owner)))
+
+	// grantee 0 is PUBLIC. the owners own privileges come with ownership
+	grantsQuery, err := sourceDbConn.Query(ctx, `
+		SELECT
+			CASE WHEN acl.grantee = 0 THEN 'PUBLIC' ELSE pg_get_userbyid(acl.grantee) END AS grantee,
+			acl.privilege_type,
+			acl.is_grantable
+		FROM pg_namespace n, aclexplode(n.nspacl) acl
+		WHERE n.nspname = $1
+		AND acl.grantee <> n.nspowner
+		ORDER BY grantee, acl.privilege_type;
+	`, schema.Source)
+	if err != nil {
+		fmt.Println("error while getting grants of source schema " + schema.Source)
+		return nil, err
+	}
+
+	grants, err := pgx.CollectRows(grantsQuery, pgx.RowToStructByName[schemaGrant])
+	if err != nil {
+		fmt.Println("error while collecting schema grant rows")
+		return nil, err
+	}
+
+	for _, grant := range grants {
+		// PUBLIC is a keyword, not a role, and must stay unquoted
+		grantee := "PUBLIC"
+		if grant.Grantee != "PUBLIC" {
+			grantee = quoteIdentifier(grant.Grantee)
+		}
+
+		query := fmt.Sprintf("GRANT %s ON SCHEMA %s TO %s", grant.PrivilegeType, quoteIdentifier(schema.Target), grantee)
+		if grant.IsGrantable {
+			query += " WITH GRANT OPTION"
+		}
+		queries = append(queries, query+";")
+	}
+
+	return queries, nil
+}
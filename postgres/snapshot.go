@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/briandowns/spinner"
+)
+
+// SnapshotVersion is bumped whenever the snapshot layout changes in a way
+// older versions of gograte can not read
+const SnapshotVersion = 1
+
+// Snapshot is a database schema serialized to a file. it deliberately holds no
+// timestamps or connection details so snapshots of an unchanged schema are
+// byte for byte identical and diff cleanly in git
+type Snapshot struct {
+	Version int                       `json:"version"`
+	Driver  string                    `json:"driver"`
+	Schemas map[string]SnapshotSchema `json:"schemas"`
+}
+
+type SnapshotSchema struct {
+	Tables map[string]Table `json:"tables"`
+}
+
+func SnapshotMethod(source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter, output string) error {
+	// writes the source schemas to a file that can be committed and reviewed
+	// without access to the database
+
+	spinner.Start()
+	snapshot, numOfTables, err := buildSnapshot(source, ctx, spinner, schemas, filter)
+	spinner.Stop()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		fmt.Println("error while serializing snapshot")
+		return err
+	}
+	data = append(data, '\n')
+
+	if output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		fmt.Println("error while writing snapshot file")
+		return err
+	}
+
+	fmt.Printf("snapshot of %v schemas and %v tables written to %v\n", len(snapshot.Schemas), numOfTables, output)
+	return nil
+}
+
+// buildSnapshot reads the source schemas into a snapshot and counts the tables
+func buildSnapshot(source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter) (Snapshot, int, error) {
+	spinner.Suffix = " getting table details"
+
+	snapshot := Snapshot{
+		Version: SnapshotVersion,
+		Driver:  "postgres",
+		Schemas: make(map[string]SnapshotSchema),
+	}
+
+	numOfTables := 0
+	for _, schema := range schemas {
+		tables, err := source.getSchemaDetails(ctx, spinner, schema.Source, true, filter)
+		if err != nil {
+			fmt.Println("error while getting source table schema")
+			return Snapshot{}, 0, err
+		}
+
+		snapshot.Schemas[schema.Source] = SnapshotSchema{Tables: tables}
+		numOfTables += len(tables)
+	}
+
+	return snapshot, numOfTables, nil
+}
@@ -0,0 +1,223 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+// SchemaSource is somewhere table structures can be read from: a live
+// database, a snapshot file or a directory of sql files
+type SchemaSource interface {
+	getSchemaDetails(ctx context.Context, spinner *spinner.Spinner, schema string, getConstraints bool, filter Filter) (map[string]Table, error)
+	// what to show the user when asking which database they are about to change
+	describe() string
+}
+
+type liveSchema struct {
+	conn *pgx.Conn
+}
+
+// LiveSchema reads table structures from a connected database
+func LiveSchema(conn *pgx.Conn) SchemaSource {
+	return liveSchema{conn: conn}
+}
+
+func (l liveSchema) getSchemaDetails(ctx context.Context, spinner *spinner.Spinner, schema string, getConstraints bool, filter Filter) (map[string]Table, error) {
+	return getSchemaDetails(l.conn, ctx, spinner, schema, getConstraints, filter)
+}
+
+func (l liveSchema) describe() string {
+	return l.conn.Config().Host
+}
+
+type snapshotSchema struct {
+	path     string
+	snapshot Snapshot
+}
+
+// LoadSnapshot reads table structures from a file written by the snapshot command
+func LoadSnapshot(path string) (SchemaSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading snapshot: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("error while parsing snapshot %v: %w", path, err)
+	}
+
+	if snapshot.Version < 1 || snapshot.Version > SnapshotVersion {
+		return nil, fmt.Errorf("snapshot %v has version %v, this version of gograte reads up to version %v", path, snapshot.Version, SnapshotVersion)
+	}
+	if snapshot.Driver != "postgres" {
+		return nil, fmt.Errorf("snapshot %v is for '%v', not postgres", path, snapshot.Driver)
+	}
+
+	return snapshotSchema{path: path, snapshot: snapshot}, nil
+}
+
+func (s snapshotSchema) getSchemaDetails(ctx context.Context, spinner *spinner.Spinner, schema string, getConstraints bool, filter Filter) (map[string]Table, error) {
+	if schema == "" {
+		schema = "public"
+	}
+
+	// a schema missing from the snapshot is the same as an empty schema in a
+	// live database
+	tables := make(map[string]Table)
+	for name, table := range s.snapshot.Schemas[schema].Tables {
+		if !filter.MatchesTable(name) {
+			continue
+		}
+
+		if !getConstraints || filter.SkipPrimaryKeys {
+			table.PrimaryKey = ""
+		}
+		if !getConstraints || filter.SkipForeignKeys {
+			table.ForeignKeys = nil
+		}
+
+		tables[name] = table
+	}
+
+	return tables, nil
+}
+
+func (s snapshotSchema) describe() string {
+	return s.path
+}
+
+type ddlSchema struct {
+	files  []string
+	config *pgx.ConnConfig
+}
+
+// IsDDLSource reports whether a --source path holds sql files rather than a
+// snapshot: a directory, or a single .sql file
+func IsDDLSource(path string) bool {
+	if path == "" {
+		return false
+	}
+	if strings.EqualFold(filepath.Ext(path), ".sql") {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// LoadDDL reads table structures from plain CREATE TABLE/INDEX/TYPE files. the
+// files are run against a throwaway schema on the given database inside a
+// transaction that is always rolled back, so postgres itself does the parsing
+// and nothing is left behind. the files describe a single schema and must use
+// unqualified names
+func LoadDDL(path string, dbConn *pgx.Conn) (SchemaSource, error) {
+	files := []string{path}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading ddl source: %w", err)
+	}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.sql"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no .sql files found in %v", path)
+		}
+		sort.Strings(files)
+	}
+
+	// a connection of its own so loading the files never touches a
+	// transaction that is open on the main connection. the throwaway schema
+	// is no change to the target, so it stays out of the audit log
+	config := dbConn.Config().Copy()
+	config.Tracer = nil
+	return ddlSchema{files: files, config: config}, nil
+}
+
+func (d ddlSchema) getSchemaDetails(ctx context.Context, spinner *spinner.Spinner, schema string, getConstraints bool, filter Filter) (map[string]Table, error) {
+	spinner.Suffix = " loading sql files"
+
+	conn, err := pgx.ConnectConfig(ctx, d.config)
+	if err != nil {
+		fmt.Println("error while connecting to database to load sql files")
+		return nil, err
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		fmt.Println("error while beginning transaction")
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // NEVER commit, this is only for parsing
+
+	scratch := fmt.Sprintf("gograte_scratch_%d", time.Now().UnixNano())
+	if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE SCHEMA %s;", quoteIdentifier(scratch))); err != nil {
+		fmt.Println("error while creating scratch schema")
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL search_path TO %s;", quoteIdentifier(scratch))); err != nil {
+		fmt.Println("error while setting search path to scratch schema")
+		return nil, err
+	}
+
+	for _, file := range d.files {
+		ddl, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading %v: %w", file, err)
+		}
+
+		// no arguments means the simple protocol, which runs every statement in the file
+		if _, err := tx.Exec(ctx, string(ddl)); err != nil {
+			return nil, fmt.Errorf("error in %v: %w", file, err)
+		}
+	}
+
+	tables, err := getSchemaDetails(tx, ctx, spinner, scratch, getConstraints, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// references between the loaded tables point at the scratch schema, make
+	// them point at the schema the files stand in for. that covers foreign
+	// keys, types from the files that are not on the search path, and
+	// defaults like nextval('gograte_scratch_1.users_id_seq'::regclass)
+	if schema == "" {
+		schema = "public"
+	}
+	for name, table := range tables {
+		for i, fk := range table.ForeignKeys {
+			if fk.ForeignSchemaName == scratch {
+				table.ForeignKeys[i].ForeignSchemaName = schema
+			}
+		}
+		for i, col := range table.Columns {
+			table.Columns[i].ColumnType = strings.ReplaceAll(col.ColumnType, scratch+".", quoteIdentifier(schema)+".")
+			if col.ColumnDefault != nil {
+				def := strings.ReplaceAll(*col.ColumnDefault, scratch+".", quoteIdentifier(schema)+".")
+				table.Columns[i].ColumnDefault = &def
+			}
+		}
+		tables[name] = table
+	}
+
+	return tables, nil
+}
+
+func (d ddlSchema) describe() string {
+	if len(d.files) == 1 {
+		return d.files[0]
+	}
+	return filepath.Dir(d.files[0])
+}
@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Subset describes a slice of the source data to copy instead of everything.
+// rows of Table matching Where are the root, every row they reference
+// (directly or through other tables) is copied along with them
+type Subset struct {
+	Table string // table or schema.table, schema being the source schema
+	Where string
+}
+
+func (s Subset) Enabled() bool {
+	return s.Table != ""
+}
+
+// resolveSubsetRoot finds the root table of a subset among the tables of this run
+func resolveSubsetRoot(subset Subset, structures map[SchemaMapping]map[string]Table, schemas []SchemaMapping) (tableRef, error) {
+	schemaName, table, qualified := strings.Cut(subset.Table, ".")
+	if !qualified {
+		table, schemaName = schemaName, ""
+	}
+
+	for _, schema := range schemas {
+		if qualified && schema.Source != schemaName {
+			continue
+		}
+		if _, exists := structures[schema][table]; exists {
+			return tableRef{Schema: schema, Table: table}, nil
+		}
+	}
+
+	return tableRef{}, fmt.Errorf("subset table '%v' was not found in the source", subset.Table)
+}
+
+// subsetQueries works out which rows of every table have to be copied. each
+// table gets a query against the source: the root selects the rows matching
+// the users clause and a referenced table the rows its referencing tables
+// point at. tables without a query are not needed at all.
+//
+// the rows a table selects are a named query (WITH "subset_n" AS ...) that the
+// tables it references select against, so a table that is reached along many
+// paths is still only spelled out and run once per query.
+//
+// order must be a dependency order (parents first) so walking it backwards
+// visits every table before the tables it references. foreign key cycles
+// other than self references can not be fully followed that way
+func subsetQueries(root tableRef, where string, structures map[SchemaMapping]map[string]Table, schemas []SchemaMapping, order []tableRef) map[tableRef]string {
+	queries := make(map[tableRef]string)
+
+	if strings.TrimSpace(where) == "" {
+		where = "TRUE"
+	}
+
+	// parent -> the IN (...) clauses pointing at it
+	references := make(map[tableRef][]string)
+
+	// the named queries of the tables visited so far
+	var selected []string
+
+	for i, ref := range slices.Backward(order) {
+		var clauses []string
+		if ref == root {
+			clauses = append(clauses, "("+where+")")
+		}
+		clauses = append(clauses, references[ref]...)
+
+		if len(clauses) == 0 {
+			continue
+		}
+		predicate := strings.Join(clauses, " OR ")
+
+		table := structures[ref.Schema][ref.Table]
+
+		// self references pull in the whole chain of rows above the selected
+		// ones (parent_id -> id -> parent_id ...)
+		for _, fk := range table.ForeignKeys {
+			parent, found := foreignKeyParent(fk, structures, schemas)
+			if !found || parent != ref {
+				continue
+			}
+			predicate = fmt.Sprintf(
+				"%[1]s IN (WITH RECURSIVE chain AS (SELECT %[1]s, %[2]s FROM %[3]s WHERE %[4]s UNION SELECT t.%[1]s, t.%[2]s FROM %[3]s t JOIN chain c ON t.%[1]s = c.%[2]s) SELECT %[1]s FROM chain)",
+				quoteIdentifier(fk.ForeignColumnName),
+				quoteIdentifier(fk.SourceColumn),
+				quoteIdentifier(ref.Schema.Source, ref.Table),
+				predicate,
+			)
+		}
+
+		name := quoteIdentifier(fmt.Sprintf("subset_%d", i))
+		selected = append(selected, fmt.Sprintf("%s AS (SELECT * FROM %s WHERE %s)", name, quoteIdentifier(ref.Schema.Source, ref.Table), predicate))
+
+		// the ones this table does not use are never run
+		queries[ref] = fmt.Sprintf("WITH %s SELECT * FROM %s", strings.Join(selected, ", "), name)
+
+		// every table this one references only needs the rows that are pointed at
+		for _, fk := range table.ForeignKeys {
+			parent, found := foreignKeyParent(fk, structures, schemas)
+			if !found || parent == ref {
+				continue
+			}
+			references[parent] = append(references[parent], fmt.Sprintf(
+				"%s IN (SELECT %s FROM %s)",
+				quoteIdentifier(fk.ForeignColumnName),
+				quoteIdentifier(fk.SourceColumn),
+				name,
+			))
+		}
+	}
+
+	return queries
+}
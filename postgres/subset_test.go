@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSubsetQueries(t *testing.T) {
+	public := SchemaMapping{Source: "public", Target: "public"}
+	schemas := []SchemaMapping{public}
+
+	fk := func(column, table string) ForeignKey {
+		return ForeignKey{SourceColumn: column, ForeignSchemaName: "public", ForeignTableName: table, ForeignColumnName: "id"}
+	}
+
+	// accounts <- users <- orders -> products, categories references itself
+	structures := map[SchemaMapping]map[string]Table{public: {
+		"accounts":   {},
+		"users":      {ForeignKeys: []ForeignKey{fk("account_id", "accounts")}},
+		"products":   {ForeignKeys: []ForeignKey{fk("category_id", "categories")}},
+		"categories": {ForeignKeys: []ForeignKey{fk("parent_id", "categories")}},
+		"orders":     {ForeignKeys: []ForeignKey{fk("user_id", "users"), fk("product_id", "products")}},
+		"reviews":    {ForeignKeys: []ForeignKey{fk("product_id", "products")}},
+	}}
+	order := dependencyOrder(structures, schemas)
+
+	tests := []struct {
+		name      string
+		root      string
+		where     string
+		want      map[string][]string // table -> parts its query must contain
+		notNeeded []string
+	}{
+		{
+			name:  "root and everything it references",
+			root:  "orders",
+			where: "id < 10",
+			want: map[string][]string{
+				"orders":     {`AS (SELECT * FROM "public"."orders" WHERE (id < 10))`},
+				"users":      {`"id" IN (SELECT "user_id" FROM "subset_`},
+				"accounts":   {`"id" IN (SELECT "account_id" FROM "subset_`},
+				"products":   {`"id" IN (SELECT "product_id" FROM "subset_`},
+				"categories": {`"id" IN (SELECT "category_id" FROM "subset_`, "WITH RECURSIVE chain"},
+			},
+			notNeeded: []string{"reviews"},
+		},
+		{
+			name: "empty clause selects every root row",
+			root: "users",
+			want: map[string][]string{
+				"users":    {`WHERE (TRUE)`},
+				"accounts": {`"id" IN (SELECT "account_id" FROM "subset_`},
+			},
+			notNeeded: []string{"orders", "products", "categories", "reviews"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := subsetQueries(tableRef{Schema: public, Table: tt.root}, tt.where, structures, schemas, order)
+
+			for table, parts := range tt.want {
+				query, needed := queries[tableRef{Schema: public, Table: table}]
+				if !needed {
+					t.Errorf("table %v has no query", table)
+					continue
+				}
+				for _, part := range parts {
+					if !strings.Contains(query, part) {
+						t.Errorf("query of %v does not contain %q: %v", table, part, query)
+					}
+				}
+			}
+			for _, table := range tt.notNeeded {
+				if query, needed := queries[tableRef{Schema: public, Table: table}]; needed {
+					t.Errorf("table %v is not referenced but got a query: %v", table, query)
+				}
+			}
+		})
+	}
+}
+
+// every table is spelled out once per query, however many paths lead to it
+func TestSubsetQueriesDiamond(t *testing.T) {
+	public := SchemaMapping{Source: "public", Target: "public"}
+	schemas := []SchemaMapping{public}
+
+	// each layer references both tables of the layer above it
+	tables := map[string]Table{"l0_a": {}, "l0_b": {}}
+	for layer := 1; layer <= 12; layer++ {
+		for _, side := range []string{"a", "b"} {
+			var fks []ForeignKey
+			for _, parent := range []string{"a", "b"} {
+				fks = append(fks, ForeignKey{
+					SourceColumn:      parent + "_id",
+					ForeignSchemaName: "public",
+					ForeignTableName:  fmt.Sprintf("l%v_%v", layer-1, parent),
+					ForeignColumnName: "id",
+				})
+			}
+			tables[fmt.Sprintf("l%v_%v", layer, side)] = Table{ForeignKeys: fks}
+		}
+	}
+	structures := map[SchemaMapping]map[string]Table{public: tables}
+	order := dependencyOrder(structures, schemas)
+
+	root := tableRef{Schema: public, Table: "l12_a"}
+	queries := subsetQueries(root, "id = 1", structures, schemas, order)
+
+	for ref, query := range queries {
+		if n := strings.Count(query, `FROM "public".`); n > len(tables) {
+			t.Errorf("query of %v reads tables %v times, there are only %v", ref.Table, n, len(tables))
+		}
+	}
+}
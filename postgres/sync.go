@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+// SyncOptions are the knobs of SyncMethod that are not about which schemas or
+// tables to work on
+type SyncOptions struct {
+	// run changes that throw data away, like dropping columns and tables
+	AllowDestructive bool
+
+	// build indexes and validate constraints outside the main transaction so
+	// large tables are not locked while they are scanned
+	Online bool
+
+	// skip the prompt, for running without a terminal
+	Yes bool
+
+	// applied to the transaction that runs the changes
+	Timeouts Timeouts
+
+	// sql files and shell commands to run around the sync, nil runs none
+	Hooks *Hooks
+}
+
+func SyncMethod(targetDbConn *pgx.Conn, source SchemaSource, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter, options SyncOptions) error {
+	// unlike replace, only changes what differs and keeps the rows in the
+	// target. the changes are the same ones diff reports
+
+	spinner.Start()
+	spinner.Suffix = " getting table details"
+
+	for _, schema := range schemas {
+		exists, err := schemaExists(targetDbConn, ctx, schema.Target)
+		if err != nil {
+			spinner.Stop()
+			return err
+		}
+		if !exists {
+			spinner.Stop()
+			return fmt.Errorf("target schema '%v' does not exist, create it first or use replace", schema.Target)
+		}
+	}
+
+	var changes []Change
+	for _, schema := range schemas {
+		sourceTables, err := source.getSchemaDetails(ctx, spinner, schema.Source, true, filter)
+		if err != nil {
+			spinner.Stop()
+			fmt.Println("error while getting source table schema")
+			return err
+		}
+
+		targetTables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, true, filter)
+		if err != nil {
+			spinner.Stop()
+			fmt.Println("error while getting target table schema")
+			return err
+		}
+
+		changes = append(changes, planSchemaChanges(sourceTables, targetTables, schema, schemas, options.Online)...)
+	}
+
+	spinner.Stop()
+
+	if len(changes) == 0 {
+		fmt.Println("target is already in sync")
+		return nil
+	}
+
+	fmt.Println("source: " + source.describe())
+	fmt.Println("target: " + targetDbConn.Config().Host)
+	fmt.Printf("\n%v changes (%v safe, %v risky, %v destructive):\n", len(changes), countChanges(changes, Safe), countChanges(changes, Risky), countChanges(changes, Destructive))
+	for _, change := range changes {
+		fmt.Println("  " + changeLabel(change))
+		for _, line := range outsideTransaction(change) {
+			fmt.Println("      " + line)
+		}
+	}
+	fmt.Println()
+
+	if n := countChanges(changes, Destructive); n > 0 && !options.AllowDestructive {
+		return fmt.Errorf("refusing to run %v destructive changes without --allow-destructive", n)
+	}
+
+	if !options.Yes && !askYesNo("apply these changes to the target?") {
+		return ErrAborted
+	}
+
+	run := hookRun{command: "sync", targetConn: targetDbConn}
+
+	// the table hooks run for every table that has changes
+	changedTables := make(map[string][]string)
+	for _, change := range changes {
+		if !slices.Contains(changedTables[change.Schema], change.Table) {
+			changedTables[change.Schema] = append(changedTables[change.Schema], change.Table)
+		}
+	}
+
+	startTime := time.Now()
+	spinner.Start()
+
+	if err := options.Hooks.runBeforeTransaction(ctx, spinner, run, changedTables); err != nil {
+		spinner.Stop()
+		return err
+	}
+
+	// CREATE INDEX CONCURRENTLY can not run in a transaction block. a failed
+	// one leaves an invalid index behind that has to be dropped by hand
+	for _, change := range changes {
+		for _, statement := range change.Before {
+			spinner.Suffix = " " + change.Description
+
+			if _, err := targetDbConn.Exec(ctx, statement); err != nil {
+				spinner.Stop()
+				fmt.Println("error while applying change: " + change.Description)
+				return err
+			}
+		}
+	}
+
+	// running into the lock timeout rolls everything back, so the whole
+	// transaction is tried again
+	err := options.Timeouts.retryOnLockTimeout(spinner, func() error {
+		tx, err := targetDbConn.Begin(ctx)
+		if err != nil {
+			fmt.Println("error while beginning transaction")
+			return err
+		}
+		defer tx.Rollback(ctx) // rollback if we dont commit!!!!!!
+
+		if err := options.Timeouts.apply(tx, ctx); err != nil {
+			return err
+		}
+
+		if err := options.Hooks.runBefore(tx, ctx, spinner, run); err != nil {
+			return err
+		}
+		if err := options.Hooks.runTables(tx, ctx, spinner, run, changedTables, false); err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			spinner.Suffix = " " + change.Description
+
+			for _, statement := range change.Statements {
+				if _, err := tx.Exec(ctx, statement); err != nil {
+					fmt.Println("error while applying change: " + change.Description)
+					return err
+				}
+			}
+		}
+
+		if err := options.Hooks.runTables(tx, ctx, spinner, run, changedTables, true); err != nil {
+			return err
+		}
+		if err := options.Hooks.runAfter(tx, ctx, spinner, run); err != nil {
+			return err
+		}
+
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		spinner.Stop()
+		return err
+	}
+
+	// validating takes long but only blocks schema changes, not writes. the
+	// main transaction is committed by now, so a failure here is not undone
+	var after []string
+	for _, change := range changes {
+		after = append(after, change.After...)
+	}
+	for i, statement := range after {
+		spinner.Suffix = " running steps after the transaction"
+
+		if _, err := targetDbConn.Exec(ctx, statement); err != nil {
+			spinner.Stop()
+			fmt.Println("error after the main transaction was committed, these statements still have to be run:")
+			for _, remaining := range after[i:] {
+				fmt.Println("  " + remaining)
+			}
+			return err
+		}
+	}
+
+	if err := options.Hooks.runAfterCommit(ctx, spinner, run, changedTables); err != nil {
+		spinner.Stop()
+		fmt.Println("the sync was committed, but an after hook failed")
+		return err
+	}
+	spinner.Stop()
+
+	fmt.Printf("\nApplied %v changes in %v seconds\n", len(changes), time.Since(startTime))
+	return nil
+}
@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Timeouts keep DDL from queueing behind a long running query while it holds
+// locks that block everyone else. zero means no timeout
+type Timeouts struct {
+	Lock      time.Duration
+	Statement time.Duration
+
+	// how many times a transaction that ran into the lock timeout is retried
+	LockRetries int
+}
+
+// apply sets the timeouts for the rest of the transaction only
+func (t Timeouts) apply(tx pgx.Tx, ctx context.Context) error {
+	if t.Lock > 0 {
+		if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL lock_timeout = %d;", t.Lock.Milliseconds())); err != nil {
+			fmt.Println("error while setting lock timeout")
+			return err
+		}
+	}
+	if t.Statement > 0 {
+		if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d;", t.Statement.Milliseconds())); err != nil {
+			fmt.Println("error while setting statement timeout")
+			return err
+		}
+	}
+	return nil
+}
+
+// retryOnLockTimeout runs attempt again, waiting longer every time, for as long
+// as it fails on the lock timeout and there are retries left. attempt must do
+// all its work in a transaction of its own so a failed try leaves nothing behind
+func (t Timeouts) retryOnLockTimeout(spinner *spinner.Spinner, attempt func() error) error {
+	wait := time.Second
+	for try := 0; ; try++ {
+		err := attempt()
+		if err == nil || !isLockTimeout(err) || try >= t.LockRetries {
+			return err
+		}
+
+		spinner.Stop()
+		fmt.Printf("could not get a lock within %v, retrying in %v (%v/%v)\n", t.Lock, wait, try+1, t.LockRetries)
+		time.Sleep(wait)
+		spinner.Start()
+
+		wait = min(wait*2, 30*time.Second)
+	}
+}
+
+func isLockTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "55P03" // lock_not_available
+}
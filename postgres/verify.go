@@ -0,0 +1,249 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/briandowns/spinner"
+	"github.com/jackc/pgx/v5"
+)
+
+type VerifyOptions struct {
+	// compare a checksum of the table contents, not only the row counts
+	Checksums bool
+	// checksum every n rows (ordered by primary key) instead of whole tables,
+	// which points at where tables differ. 0 checksums whole tables
+	ChunkSize int
+}
+
+type tableChecksum struct {
+	Chunk    int64  `db:"chunk"`
+	Rows     int64  `db:"rows"`
+	Checksum string `db:"checksum"`
+}
+
+type tableVerification struct {
+	ref             tableRef
+	sourceRows      int64
+	targetRows      int64
+	checksumStatus  string
+	mismatchedChunk []int64
+	failure         string
+}
+
+func (v tableVerification) passed() bool {
+	return v.failure == "" && v.sourceRows == v.targetRows && len(v.mismatchedChunk) == 0 && v.checksumStatus != "mismatch"
+}
+
+func VerifyMethod(targetDbConn, sourceDbConn *pgx.Conn, ctx context.Context, spinner *spinner.Spinner, schemas []SchemaMapping, filter Filter, options VerifyOptions) error {
+	// proves the target holds the same data as the source, table by table
+
+	spinner.Start()
+
+	if options.Checksums {
+		for _, dbConn := range []*pgx.Conn{sourceDbConn, targetDbConn} {
+			if err := setChecksumSettings(dbConn, ctx); err != nil {
+				spinner.Stop()
+				fmt.Println("error while setting up the connection for checksums")
+				return err
+			}
+		}
+	}
+
+	spinner.Suffix = " getting table details"
+
+	var results []tableVerification
+	for _, schema := range schemas {
+		sourceTables, err := getSchemaDetails(sourceDbConn, ctx, spinner, schema.Source, true, filter)
+		if err != nil {
+			fmt.Println("error while getting source table schema")
+			return err
+		}
+
+		targetTables, err := getSchemaDetails(targetDbConn, ctx, spinner, schema.Target, false, filter)
+		if err != nil {
+			fmt.Println("error while getting target table schema")
+			return err
+		}
+
+		for _, table := range sortedTableNames(sourceTables) {
+			ref := tableRef{Schema: schema, Table: table}
+			spinner.Suffix = fmt.Sprintf(" verifying table %v.%v", schema.Target, table)
+
+			targetTable, exists := targetTables[table]
+			if !exists {
+				results = append(results, tableVerification{ref: ref, failure: "missing in target"})
+				continue
+			}
+
+			result, err := verifyTable(targetDbConn, sourceDbConn, ctx, ref, sourceTables[table], targetTable, options)
+			if err != nil {
+				fmt.Printf("error while verifying table %v.%v\n", schema.Target, table)
+				return err
+			}
+			results = append(results, result)
+		}
+
+		for _, table := range sortedTableNames(targetTables) {
+			if _, exists := sourceTables[table]; !exists {
+				results = append(results, tableVerification{ref: tableRef{Schema: schema, Table: table}, failure: "missing in source"})
+			}
+		}
+	}
+
+	spinner.Stop()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tSOURCE ROWS\tTARGET ROWS\tCHECKSUM\tRESULT")
+
+	numOfFailures := 0
+	for _, result := range results {
+		status := "PASS"
+		if !result.passed() {
+			status = "FAIL"
+			numOfFailures++
+		}
+
+		checksum := result.checksumStatus
+		if len(result.mismatchedChunk) > 0 {
+			checksum = fmt.Sprintf("chunks %v differ", joinInts(result.mismatchedChunk))
+		}
+		if result.failure != "" {
+			checksum = result.failure
+		}
+		if checksum == "" {
+			checksum = "-"
+		}
+
+		fmt.Fprintf(w, "%s.%s\t%v\t%v\t%s\t%s\n", result.ref.Schema.Target, result.ref.Table, result.sourceRows, result.targetRows, checksum, status)
+	}
+	w.Flush()
+
+	if numOfFailures > 0 {
+		return fmt.Errorf("verification failed for %v of %v tables", numOfFailures, len(results))
+	}
+
+	fmt.Printf("\nverified %v tables, all passed\n", len(results))
+	return nil
+}
+
+func verifyTable(targetDbConn, sourceDbConn *pgx.Conn, ctx context.Context, ref tableRef, sourceTable, targetTable Table, options VerifyOptions) (tableVerification, error) {
+	result := tableVerification{ref: ref}
+
+	if !options.Checksums {
+		if err := sourceDbConn.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM %s;", quoteIdentifier(ref.Schema.Source, ref.Table))).Scan(&result.sourceRows); err != nil {
+			return result, err
+		}
+		if err := targetDbConn.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM %s;", quoteIdentifier(ref.Schema.Target, ref.Table))).Scan(&result.targetRows); err != nil {
+			return result, err
+		}
+		return result, nil
+	}
+
+	// only the columns both sides have can be compared, in the same order
+	targetCols := columnNames(targetTable.Columns)
+	var columns []string
+	for _, col := range sourceTable.Columns {
+		for _, targetCol := range targetCols {
+			if col.ColumnName == targetCol {
+				columns = append(columns, quoteIdentifier(col.ColumnName))
+				break
+			}
+		}
+	}
+
+	sourceChecksums, err := queryChecksums(sourceDbConn, ctx, quoteIdentifier(ref.Schema.Source, ref.Table), columns, sourceTable.PrimaryKey, options.ChunkSize)
+	if err != nil {
+		return result, err
+	}
+	targetChecksums, err := queryChecksums(targetDbConn, ctx, quoteIdentifier(ref.Schema.Target, ref.Table), columns, sourceTable.PrimaryKey, options.ChunkSize)
+	if err != nil {
+		return result, err
+	}
+
+	for _, c := range sourceChecksums {
+		result.sourceRows += c.Rows
+	}
+	for _, c := range targetChecksums {
+		result.targetRows += c.Rows
+	}
+
+	result.checksumStatus = "match"
+	numOfChunks := max(len(sourceChecksums), len(targetChecksums))
+	for i := 0; i < numOfChunks; i++ {
+		if i < len(sourceChecksums) && i < len(targetChecksums) && sourceChecksums[i] == targetChecksums[i] {
+			continue
+		}
+		if options.ChunkSize > 0 {
+			result.mismatchedChunk = append(result.mismatchedChunk, int64(i))
+		} else {
+			result.checksumStatus = "mismatch"
+		}
+	}
+
+	return result, nil
+}
+
+// setChecksumSettings makes the text form of every value the same on both
+// sides. timestamps with time zone, dates, intervals, floats and bytea are
+// written according to these, and the two servers may be set up differently
+func setChecksumSettings(dbConn *pgx.Conn, ctx context.Context) error {
+	_, err := dbConn.Exec(ctx, `
+		SET TimeZone = 'UTC';
+		SET DateStyle = 'ISO, MDY';
+		SET IntervalStyle = 'postgres';
+		SET extra_float_digits = 3;
+		SET bytea_output = 'hex';
+	`)
+	return err
+}
+
+// queryChecksums hashes the text form of every row, ordered by the primary key
+// (or the whole row when there is none) so both sides hash in the same order.
+// only the row hashes are strung together, so a large table does not build a
+// string of its whole contents
+func queryChecksums(dbConn *pgx.Conn, ctx context.Context, table string, columns []string, primaryKey string, chunkSize int) ([]tableChecksum, error) {
+	row := "ROW(" + strings.Join(columns, ", ") + ")::text"
+	if len(columns) == 0 {
+		row = "''"
+	}
+
+	orderBy := row
+	if primaryKey != "" {
+		orderBy = quoteIdentifier(primaryKey)
+	}
+
+	chunk := "0"
+	if chunkSize > 0 {
+		chunk = fmt.Sprintf("(row_number() OVER (ORDER BY %s) - 1) / %d", orderBy, chunkSize)
+	}
+
+	query, err := dbConn.Query(ctx, fmt.Sprintf(`
+		SELECT
+			chunk,
+			count(*) AS rows,
+			md5(coalesce(string_agg(md5(r), '' ORDER BY ord), '')) AS checksum
+		FROM (
+			SELECT %s AS chunk, %s AS r, %s AS ord
+			FROM %s
+		) t
+		GROUP BY chunk
+		ORDER BY chunk;
+	`, chunk, row, orderBy, table))
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(query, pgx.RowToStructByName[tableChecksum])
+}
+
+func joinInts(values []int64) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, fmt.Sprint(v))
+	}
+	return strings.Join(parts, ",")
+}